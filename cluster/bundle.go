@@ -0,0 +1,300 @@
+package cluster
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urvil38/kmanager/config"
+	"github.com/urvil38/kmanager/questions"
+)
+
+const (
+	// BundleManifestFile lists the files of a bundle with their checksums.
+	BundleManifestFile = "manifest.json"
+	// BundleFormat is the version of the bundle layout written by
+	// ExportBundle.
+	BundleFormat = 1
+
+	// maxBundleFileSize bounds every file read from a bundle.
+	maxBundleFileSize = 32 << 20
+)
+
+// BundleManifest describes a bundle made by ExportBundle.
+type BundleManifest struct {
+	Format        int          `json:"format"`
+	Cluster       string       `json:"cluster"`
+	SchemaVersion int          `json:"schema_version"`
+	Created       time.Time    `json:"created"`
+	Files         []BundleFile `json:"files"`
+}
+
+// BundleFile is a file of a bundle.
+type BundleFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Bundle is a verified bundle read by ReadBundle.
+type Bundle struct {
+	Manifest BundleManifest
+	Files    map[string][]byte
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyFiles returns the names of the key files of c, plain and encrypted.
+func (c *Cluster) keyFiles() map[string]bool {
+	keys := make(map[string]bool)
+	for _, name := range []string{c.ServiceAccount.DNSName, c.ServiceAccount.CloudBuildName, c.ServiceAccount.StorageName} {
+		keys[name+".json"] = true
+		keys[name+".json"+config.EncryptedExt] = true
+	}
+	return keys
+}
+
+// ExportBundle writes c as a gzipped tarball to w: its config, the files of
+// its config dir like the rendered kubeapp manifests and, with includeKeys,
+// its service account keys. Keys are only ever exported encrypted, keys
+// kept in plaintext are encrypted with the passphrase for the bundle. The
+// kubeconfig is left out, it is fetched anew where the bundle is imported.
+func (c *Cluster) ExportBundle(w io.Writer, includeKeys bool) (*BundleManifest, error) {
+	files := make(map[string][]byte)
+
+	// ConfPath is stored relative to the cluster dir, so it is valid on
+	// the importing machine too
+	b, err := c.marshal()
+	if err != nil {
+		return nil, err
+	}
+	files[config.ConfigFile] = b
+
+	keys := c.keyFiles()
+	fis, err := ioutil.ReadDir(c.ConfPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || strings.HasPrefix(name, ".") || name == config.ConfigFile || strings.HasSuffix(name, ".bak") || name == KubeconfigFile || keys[name] {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(c.ConfPath, name))
+		if err != nil {
+			return nil, err
+		}
+		files[name] = b
+	}
+
+	if includeKeys {
+		for _, name := range []string{c.ServiceAccount.DNSName, c.ServiceAccount.CloudBuildName, c.ServiceAccount.StorageName} {
+			path := c.KeyPath(name)
+			sealed, err := ioutil.ReadFile(path + config.EncryptedExt)
+			if errors.Is(err, os.ErrNotExist) {
+				sealed, err = sealForBundle(path)
+			}
+			if errors.Is(err, os.ErrNotExist) {
+				// no key generated, e.g. with Workload Identity
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			files[filepath.Base(path)+config.EncryptedExt] = sealed
+		}
+	}
+
+	m := &BundleManifest{
+		Format:        BundleFormat,
+		Cluster:       c.Name,
+		SchemaVersion: SchemaVersion,
+		Created:       time.Now().UTC(),
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.Files = append(m.Files, BundleFile{Name: name, Size: int64(len(files[name])), SHA256: checksum(files[name])})
+	}
+
+	mb, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	write := func(name string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(data)),
+			ModTime:  m.Created,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	err = write(BundleManifestFile, mb)
+	for _, name := range names {
+		if err != nil {
+			break
+		}
+		err = write(name, files[name])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return m, gw.Close()
+}
+
+// sealForBundle encrypts the plaintext key at path.
+func sealForBundle(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := config.Passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("%s is not encrypted, keys are only exported encrypted: %w", path, err)
+	}
+	return config.Encrypt(data, passphrase)
+}
+
+// ReadBundle reads a bundle written by ExportBundle, checking every file
+// against the checksums of its manifest.
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	defer gr.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("invalid bundle: unexpected entry %q", name)
+		}
+		if _, dup := files[name]; dup {
+			return nil, fmt.Errorf("invalid bundle: duplicate entry %q", name)
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(tr, maxBundleFileSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+		if len(data) > maxBundleFileSize {
+			return nil, fmt.Errorf("invalid bundle: %s is larger than %d bytes", name, maxBundleFileSize)
+		}
+		files[name] = data
+	}
+
+	mb, ok := files[BundleManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("invalid bundle: no %s found", BundleManifestFile)
+	}
+	delete(files, BundleManifestFile)
+
+	b := &Bundle{Files: files}
+	err = json.Unmarshal(mb, &b.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: invalid %s: %v", BundleManifestFile, err)
+	}
+	if b.Manifest.Format > BundleFormat {
+		return nil, fmt.Errorf("bundle has format %d but this kmanager only knows up to %d, please upgrade kmanager", b.Manifest.Format, BundleFormat)
+	}
+
+	listed := make(map[string]bool)
+	for _, f := range b.Manifest.Files {
+		data, ok := files[f.Name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("corrupted bundle: %s is missing", f.Name)
+		case int64(len(data)) != f.Size || checksum(data) != f.SHA256:
+			return nil, fmt.Errorf("corrupted bundle: checksum of %s does not match", f.Name)
+		}
+		listed[f.Name] = true
+	}
+	for name := range files {
+		if !listed[name] {
+			return nil, fmt.Errorf("corrupted bundle: %s is not listed in %s", name, BundleManifestFile)
+		}
+	}
+	if !listed[config.ConfigFile] {
+		return nil, fmt.Errorf("invalid bundle: no %s found", config.ConfigFile)
+	}
+	return b, nil
+}
+
+// Cluster returns the cluster of the bundle, migrated to the current
+// schema. Its name is checked, as it names the dir the bundle is
+// installed into.
+func (b *Bundle) Cluster() (*Cluster, error) {
+	data, _, err := Migrate(b.Files[config.ConfigFile])
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cluster
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Name != b.Manifest.Cluster {
+		return nil, fmt.Errorf("corrupted bundle: it is made for cluster \"%s\" but holds the config of \"%s\"", b.Manifest.Cluster, c.Name)
+	}
+	if !questions.IsValidClusterName(c.Name) {
+		return nil, fmt.Errorf("invalid bundle: %q is not a valid cluster name", c.Name)
+	}
+	return &c, nil
+}
+
+// Install writes the files of the bundle, except the config which belongs
+// into the state store, into dir.
+func (b *Bundle) Install(dir string) error {
+	for _, f := range b.Manifest.Files {
+		if f.Name == config.ConfigFile {
+			continue
+		}
+		err := ioutil.WriteFile(filepath.Join(dir, f.Name), b.Files[f.Name], 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urvil38/kmanager/config"
+)
+
+func TestBundleCluster(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		config   string
+		wantErr  string
+	}{
+		{name: "valid", manifest: "demo", config: "demo"},
+		{name: "other cluster", manifest: "demo", config: "other", wantErr: "corrupted bundle"},
+		{name: "parent dir", manifest: "..", config: "..", wantErr: "not a valid cluster name"},
+		{name: "empty", manifest: "", config: "", wantErr: "not a valid cluster name"},
+		{name: "path", manifest: "demo/../..", config: "demo/../..", wantErr: "not a valid cluster name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bundle{
+				Manifest: BundleManifest{Format: BundleFormat, Cluster: tt.manifest},
+				Files: map[string][]byte{
+					config.ConfigFile: []byte(fmt.Sprintf(`{"schema_version":%d,"cluster_name":%q}`, SchemaVersion, tt.config)),
+				},
+			}
+
+			c, err := b.Cluster()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Cluster = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Name != tt.config {
+				t.Errorf("Cluster named %q, want %q", c.Name, tt.config)
+			}
+		})
+	}
+}
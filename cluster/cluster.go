@@ -1,12 +1,13 @@
 package cluster
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/urvil38/kmanager/config"
 )
@@ -17,17 +18,117 @@ const (
 )
 
 type Cluster struct {
-	Name              string         `json:"cluster_name" survey:"clusterName"`
-	GcloudProjectName string         `json:"project_name" survey:"project"`
-	Account           string         `json:"account"`
-	Region            string         `json:"region"`
-	Zone              string         `json:"zone"`
-	DNSName           string         `json:"dns_name" survey:"dnsName"`
-	Storage           Storage        `json:"storage"`
-	ServiceAccount    ServiceAccount `json:"service_account"`
-	KubeAppConfig     *KubeApp       `json:"kubeapp"`
-	KubeAppMap        map[string]App `json:"-"`
-	ConfPath          string         `json:"config_path"`
+	SchemaVersion     int               `json:"schema_version"`
+	Name              string            `json:"cluster_name" survey:"clusterName"`
+	GcloudProjectName string            `json:"project_name" survey:"project"`
+	Account           string            `json:"account"`
+	Region            string            `json:"region"`
+	Zone              string            `json:"zone"`
+	LocationMode      string            `json:"location_mode,omitempty"`
+	NodeLocations     []string          `json:"node_locations,omitempty"`
+	DNSName           string            `json:"dns_name" survey:"dnsName"`
+	Storage           Storage           `json:"storage"`
+	ServiceAccount    ServiceAccount    `json:"service_account"`
+	Nodes             NodeConfig        `json:"nodes"`
+	NodePools         []NodePool        `json:"node_pools,omitempty"`
+	WorkloadIdentity  bool              `json:"workload_identity,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	KubeAppConfig     *KubeApp          `json:"kubeapp"`
+	KubeAppOptions    KubeAppOptions    `json:"kubeapp_options"`
+	KubeAppMap        map[string]App    `json:"-"`
+	ConfPath          string            `json:"config_path"`
+	Exec              Executor          `json:"-"`
+	Journal           *Journal          `json:"-"`
+	state             *stateRef
+	// NonInteractive disables every prompt, values which would be asked
+	// for have to be set up front.
+	NonInteractive bool `json:"-"`
+}
+
+const (
+	DefaultMachineType  = "n1-standard-1"
+	DefaultNumNodes     = 2
+	DefaultDiskSizeGB   = 10
+	DefaultDiskType     = "pd-standard"
+	DefaultImageType    = "COS"
+	DefaultKubeAppIndex = "https://storage.googleapis.com/kmanager/index.yaml"
+)
+
+// DefaultScopes are the OAuth scopes granted to the nodes when none are
+// given. Cloud DNS read-write access is needed by cert-manager.
+var DefaultScopes = []string{
+	"https://www.googleapis.com/auth/devstorage.read_only",
+	"https://www.googleapis.com/auth/logging.write",
+	"https://www.googleapis.com/auth/monitoring",
+	"https://www.googleapis.com/auth/servicecontrol",
+	"https://www.googleapis.com/auth/service.management.readonly",
+	"https://www.googleapis.com/auth/trace.append",
+	"https://www.googleapis.com/auth/ndev.clouddns.readwrite",
+}
+
+// NodeConfig is the shape of the default node pool of the GKE cluster.
+type NodeConfig struct {
+	MachineType string   `json:"machine_type" yaml:"machineType"`
+	NumNodes    int      `json:"num_nodes" yaml:"numNodes"`
+	DiskSizeGB  int      `json:"disk_size_gb" yaml:"diskSizeGB"`
+	DiskType    string   `json:"disk_type" yaml:"diskType"`
+	ImageType   string   `json:"image_type" yaml:"imageType"`
+	Preemptible bool     `json:"preemptible" yaml:"preemptible"`
+	Scopes      []string `json:"scopes" yaml:"scopes"`
+}
+
+// DefaultNodeConfig returns the node shape used when none is given.
+func DefaultNodeConfig() NodeConfig {
+	return NodeConfig{
+		MachineType: DefaultMachineType,
+		NumNodes:    DefaultNumNodes,
+		DiskSizeGB:  DefaultDiskSizeGB,
+		DiskType:    DefaultDiskType,
+		ImageType:   DefaultImageType,
+		Preemptible: true,
+		Scopes:      append([]string{}, DefaultScopes...),
+	}
+}
+
+// Validate reports the first field of n which gcloud would reject.
+func (n NodeConfig) Validate() error {
+	switch {
+	case n.MachineType == "":
+		return errors.New("machine type is required")
+	case n.NumNodes < 1:
+		return fmt.Errorf("number of nodes must be at least 1, got %d", n.NumNodes)
+	case n.DiskSizeGB < 10:
+		return fmt.Errorf("disk size must be at least 10GB, got %d", n.DiskSizeGB)
+	case n.DiskType == "":
+		return errors.New("disk type is required")
+	case n.ImageType == "":
+		return errors.New("image type is required")
+	case len(n.Scopes) == 0:
+		return errors.New("at least one scope is required")
+	}
+	return nil
+}
+
+// KubeAppOptions selects which kubeapps get installed into the cluster.
+type KubeAppOptions struct {
+	Index   string   `json:"index,omitempty" yaml:"index"`
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude"`
+}
+
+func (o KubeAppOptions) index() string {
+	if o.Index == "" {
+		return DefaultKubeAppIndex
+	}
+	return o.Index
+}
+
+func (o KubeAppOptions) excluded(name string) bool {
+	for _, e := range o.Exclude {
+		if e == name {
+			return true
+		}
+	}
+	return false
 }
 
 type Storage struct {
@@ -87,21 +188,28 @@ func (c *Cluster) GetServiceAccountOpts() ServiceAccount {
 	return s
 }
 
+// Get loads the config of the cluster of given name from the state store.
+// The error wraps os.ErrNotExist when there is no such cluster.
 func Get(name string) (Cluster, error) {
 	var cc Cluster
 
-	configFilePath, err := config.ClusterPath(name)
+	store, err := config.Store()
 	if err != nil {
 		return cc, err
 	}
 
-	if _, err := os.Stat(filepath.Join(configFilePath, "config.json")); errors.Is(err, os.ErrNotExist) {
+	b, version, err := store.Get(context.Background(), name)
+	if errors.Is(err, config.ErrStateNotFound) {
+		return cc, fmt.Errorf("no config of cluster \"%s\": %w", name, os.ErrNotExist)
+	} else if err != nil {
 		return cc, err
 	}
 
-	b, err := ioutil.ReadFile(filepath.Join(configFilePath, "config.json"))
+	// older configs are migrated in memory, they are written back in the
+	// current schema by the next GenerateConfig
+	b, _, err = Migrate(b)
 	if err != nil {
-		return cc, err
+		return cc, fmt.Errorf("config of cluster \"%s\": %w", name, err)
 	}
 
 	err = json.Unmarshal(b, &cc)
@@ -109,17 +217,74 @@ func Get(name string) (Cluster, error) {
 		return cc, err
 	}
 
+	if !store.Local {
+		// keys and manifests are kept in a local working dir
+		cc.ConfPath, err = config.CreateConfigDir(name)
+		if err != nil {
+			return cc, err
+		}
+	} else if !filepath.IsAbs(cc.ConfPath) {
+		// stored relative to the cluster dir, so the config dir can move
+		dir, err := config.ClusterDir(name)
+		if err != nil {
+			return cc, err
+		}
+		cc.ConfPath = filepath.Join(dir, cc.ConfPath)
+	}
+
+	cc.state = &stateRef{store: store, version: version}
 	return cc, nil
 }
 
+// GenerateConfig writes c to the state store. It fails with
+// config.ErrConflict when the config was changed by someone else since it
+// was loaded, or, for a new cluster, when one of the same name exists.
 func (c Cluster) GenerateConfig() error {
-	b, err := json.MarshalIndent(c, "", "    ")
+	b, err := c.marshal()
+	if err != nil {
+		return err
+	}
+
+	if c.state == nil {
+		// not attached to a store, e.g. built by hand, just overwrite
+		store, err := config.Store()
+		if err != nil {
+			return err
+		}
+		_, err = store.Put(context.Background(), c.Name, b, config.AnyVersion)
+		return err
+	}
+
+	return c.state.put(c.Name, b)
+}
+
+// marshal returns c as stored in config.json. A ConfPath inside the
+// cluster dir is stored relative to it.
+func (c Cluster) marshal() ([]byte, error) {
+	c.SchemaVersion = SchemaVersion
+	if dir, err := config.ClusterDir(c.Name); err == nil && c.ConfPath != "" {
+		rel, err := filepath.Rel(dir, c.ConfPath)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			c.ConfPath = rel
+		}
+	}
+	return json.MarshalIndent(c, "", "    ")
+}
+
+// RemoveConfig removes c from the state store along with its working dir.
+func (c Cluster) RemoveConfig() error {
+	store, err := config.Store()
+	if c.state != nil {
+		store, err = c.state.store, nil
+	}
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(filepath.Join(c.ConfPath, "config.json"), b, 0600)
+
+	err = store.Delete(context.Background(), c.Name)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return os.RemoveAll(c.ConfPath)
 }
@@ -1,57 +1,118 @@
 package cluster
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"os/exec"
-	"strings"
+	"time"
 )
 
 type Command struct {
-	Name         string
-	RootCmd      string
-	Args         []string
+	Name    string
+	RootCmd string
+	Args    []string
+	// Env is added to the environment of the command, after the one of the
+	// cluster it runs for, as KEY=value.
+	Env []string
+	// Dir is the working directory of the command.
+	Dir string
+	// Stdin is fed to the command, e.g. a manifest for 'kubectl create -f -'.
+	Stdin        []byte
 	Stderr       error
 	Stdout       string
 	Internal     bool
 	InterActive  bool
+	ReadOnly     bool
 	Succeed      bool
 	GenerateArgs func(*Cluster) []string
-	AfterFn      func(*Command) error
+	AfterFn      func(context.Context, *Command) error
+	Executor     Executor
+	// Retry, when set, retries the command on failure.
+	Retry *RetryPolicy
+	// Timeout, when set, bounds the run of the command including retries.
+	Timeout time.Duration
+	// DependsOn names the commands of the same CmdSet which have to
+	// succeed before this one is run by CmdSet.Run.
+	DependsOn []string
 }
 
 func (c *Command) Execute(ctx context.Context, cc *Cluster) {
 	c.reset()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
 	if c.GenerateArgs != nil {
 		c.Args = c.GenerateArgs(cc)
 	}
 
-	if c.InterActive {
-		cmd := exec.CommandContext(ctx, c.RootCmd, c.Args...)
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-		cmd.Stdin = os.Stdin
+	e := c.executor(cc)
+	p := Process{
+		Name:        c.Name,
+		RootCmd:     c.RootCmd,
+		Args:        c.Args,
+		Env:         append(cc.env(), c.Env...),
+		Dir:         c.Dir,
+		Stdin:       c.Stdin,
+		InterActive: c.InterActive,
+		ReadOnly:    c.ReadOnly,
+	}
 
-		err := cmd.Run()
-		if err != nil {
+	for attempt := 1; ; attempt++ {
+		c.run(ctx, e, p)
+		if c.Succeed || c.Retry == nil || ctx.Err() != nil || !c.Retry.shouldRetry(attempt, c.Stderr) {
+			break
+		}
+
+		wait := c.Retry.backoff(attempt)
+		fmt.Printf("%s: attempt %d of %d failed, retrying in %s\n", c.Name, attempt, c.Retry.MaxAttempts, wait.Round(time.Second))
+		if sleep(ctx, wait) != nil {
+			break
+		}
+	}
+
+	if c.AfterFn != nil && !isPlanned(e, c) {
+		err := c.AfterFn(ctx, c)
+		if err != nil && c.Succeed {
+			c.Succeed = false
 			c.Stderr = err
+		}
+	}
+}
+
+func (c *Command) run(ctx context.Context, e Executor, p Process) {
+	c.reset()
+
+	if c.InterActive {
+		res, err := e.Exec(ctx, p)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			c.Stderr = newCommandError(p, res, err)
 		} else {
 			c.Succeed = true
 		}
 
 	} else {
-		c.Stdout, c.Stderr = RunCommand(ctx, c.Name, c.RootCmd, c.Args...)
+		c.Stdout, c.Stderr = RunCommand(ctx, e, p)
 		if c.Stderr == nil {
 			c.Succeed = true
 		}
 	}
+}
 
-	if c.AfterFn != nil {
-		c.AfterFn(c)
+// executor picks the executor injected into the command, then the one of
+// the cluster it runs against, falling back to DefaultExecutor.
+func (c *Command) executor(cc *Cluster) Executor {
+	if c.Executor != nil {
+		return c.Executor
+	}
+	if cc != nil && cc.Exec != nil {
+		return cc.Exec
 	}
+	return DefaultExecutor
 }
 
 func (c *Command) reset() {
@@ -78,18 +139,31 @@ func (cs *CmdSet) GetCommand(cmdName string) (Command, error) {
 }
 
 func NewCmdSet(cc *Cluster, name string) *CmdSet {
-	return &CmdSet{
+	cs := &CmdSet{
 		Name:   name,
 		CmdMap: make(map[string]Command),
 		C:      cc,
 	}
+	if cc != nil {
+		cs.Executor = cc.Exec
+	}
+	return cs
 }
 
 type CmdSet struct {
-	Name   string
-	CmdMap map[string]Command
-	Cmds   []Command
-	C      *Cluster
+	Name     string
+	CmdMap   map[string]Command
+	Cmds     []Command
+	C        *Cluster
+	Executor Executor
+	// Concurrency bounds how many commands Run executes at once.
+	Concurrency int
+	// StepTimeout is used by Run for commands without a Timeout.
+	StepTimeout time.Duration
+	// Adopt makes Run treat resources which already exist as created, as
+	// they are when resuming an earlier run. They are journaled as
+	// StepAdopted.
+	Adopt bool
 }
 
 func (cs *CmdSet) AddCmd(cmd Command) error {
@@ -98,25 +172,41 @@ func (cs *CmdSet) AddCmd(cmd Command) error {
 		log.Fatal(cmd.Name, ": command redefined!!")
 	}
 
+	if cmd.Executor == nil {
+		cmd.Executor = cs.Executor
+	}
+
 	cs.CmdMap[cmd.Name] = cmd
 	cs.Cmds = append(cs.Cmds, cmd)
 
 	return nil
 }
 
-func RunCommand(ctx context.Context, name, rootCmd string, args ...string) (output string, err error) {
-	fmt.Println(name + ": " + rootCmd + " " + strings.Join(args, " "))
-	var stdout, stderr bytes.Buffer
-	cmd := exec.CommandContext(ctx, rootCmd, args...)
-	cmd.Stderr = &stderr
-	cmd.Stdout = &stdout
+// AddCmdSet adds every command of other to cs, so dependencies can span
+// both sets.
+func (cs *CmdSet) AddCmdSet(other *CmdSet) error {
+	for _, cmd := range other.Cmds {
+		err := cs.AddCmd(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	err = cmd.Run()
+// RunCommand runs p and returns its stdout. The command line is logged
+// along with the environment of p, sensitive values redacted. A failure is
+// reported as a *CommandError carrying the captured stdout and stderr.
+// Output written to stderr by a successful command, like gcloud progress
+// messages, is not an error.
+func RunCommand(ctx context.Context, e Executor, p Process) (output string, err error) {
+	fmt.Println(p.Name + ": " + ProcessLine(p))
+	res, err := e.Exec(ctx, p)
 	if err != nil {
-		return "", err
-	}
-	if stderr.Len() > 0 {
-		return "", err
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return res.Stdout, newCommandError(p, res, err)
 	}
-	return stdout.String(), nil
+	return res.Stdout, nil
 }
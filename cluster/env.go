@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sensitiveEnv holds parts of the names of environment variables whose
+// values may be secrets. Their values are redacted wherever processes are
+// logged.
+var sensitiveEnv = []string{"TOKEN", "SECRET", "PASSWORD", "PASSPHRASE", "CREDENTIAL", "KEY"}
+
+// redacted replaces the values of sensitive variables.
+const redacted = "<redacted>"
+
+// env returns the environment of the processes run for c. gcloud is pinned
+// to the project of c, whichever project is active in the gcloud config of
+// the user. gcloud and kubectl are pointed at the kubeconfig of c, as
+// creating a cluster and fetching its credentials write to the kubeconfig
+// named by KUBECONFIG. CLOUDSDK_CONFIG is left alone: the gcloud config dir
+// also holds the credentials of the user, a dir of its own per cluster
+// would need a separate 'gcloud auth login' for every cluster.
+func (c *Cluster) env() []string {
+	if c == nil {
+		return nil
+	}
+
+	var env []string
+	if c.GcloudProjectName != "" {
+		env = append(env, "CLOUDSDK_CORE_PROJECT="+c.GcloudProjectName)
+	}
+	if c.ConfPath != "" {
+		env = append(env, "KUBECONFIG="+c.KubeconfigPath())
+	}
+	return env
+}
+
+// RedactEnv returns env with the values of variables which may hold
+// secrets masked.
+func RedactEnv(env []string) []string {
+	masked := make([]string, len(env))
+	for i, kv := range env {
+		masked[i] = kv
+		k := strings.SplitN(kv, "=", 2)[0]
+		for _, s := range sensitiveEnv {
+			if strings.Contains(strings.ToUpper(k), s) {
+				masked[i] = k + "=" + redacted
+				break
+			}
+		}
+	}
+	return masked
+}
+
+// ProcessLine renders p for logs like CommandLine, along with its
+// redacted environment, working directory and stdin.
+func ProcessLine(p Process) string {
+	line := CommandLine(p.RootCmd, p.Args)
+	if len(p.Env) > 0 {
+		var env []string
+		for _, kv := range RedactEnv(p.Env) {
+			kv := strings.SplitN(kv, "=", 2)
+			if len(kv) == 2 && kv[1] != redacted {
+				kv[1] = shellQuote(kv[1])
+			}
+			env = append(env, strings.Join(kv, "="))
+		}
+		line = strings.Join(env, " ") + " " + line
+	}
+	if p.Dir != "" {
+		line += fmt.Sprintf(" (in %s)", p.Dir)
+	}
+	if p.Stdin != nil {
+		line += fmt.Sprintf(" (%d bytes on stdin)", len(p.Stdin))
+	}
+	return line
+}
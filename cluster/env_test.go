@@ -0,0 +1,71 @@
+package cluster
+
+import "testing"
+
+func TestRedactEnv(t *testing.T) {
+	tests := []struct {
+		kv   string
+		want string
+	}{
+		{"KUBECONFIG=/home/me/.config/kmanager/demo/kubeconfig", "KUBECONFIG=/home/me/.config/kmanager/demo/kubeconfig"},
+		{"CLOUDSDK_CORE_PROJECT=proj", "CLOUDSDK_CORE_PROJECT=proj"},
+		{"PATH=/usr/bin", "PATH=/usr/bin"},
+		{"CLOUDSDK_AUTH_ACCESS_TOKEN=ya29.secret", "CLOUDSDK_AUTH_ACCESS_TOKEN=<redacted>"},
+		{"GOOGLE_APPLICATION_CREDENTIALS=/keys/sa.json", "GOOGLE_APPLICATION_CREDENTIALS=<redacted>"},
+		{"KMANAGER_PASSPHRASE=hunter2", "KMANAGER_PASSPHRASE=<redacted>"},
+		{"KMANAGER_KEY_FILE=/keys/kmanager.key", "KMANAGER_KEY_FILE=<redacted>"},
+		{"api_key=abc", "api_key=<redacted>"},
+		{"CLIENT_SECRET=", "CLIENT_SECRET=<redacted>"},
+		{"DB_PASSWORD=a=b", "DB_PASSWORD=<redacted>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kv, func(t *testing.T) {
+			got := RedactEnv([]string{tt.kv})
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("RedactEnv(%q) = %q, want %q", tt.kv, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessLine(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Process
+		want string
+	}{
+		{
+			name: "plain",
+			p:    Process{RootCmd: "gcloud", Args: []string{"container", "clusters", "list"}},
+			want: "gcloud container clusters list",
+		},
+		{
+			name: "quoted args",
+			p:    Process{RootCmd: "gcloud", Args: []string{"dns", "managed-zones", "create", "demo", "--description", "kubepaas managed zone"}},
+			want: `gcloud dns managed-zones create demo --description "kubepaas managed zone"`,
+		},
+		{
+			name: "environment",
+			p: Process{
+				RootCmd: "kubectl",
+				Args:    []string{"get", "pods"},
+				Env:     []string{"CLOUDSDK_CORE_PROJECT=proj", "KUBECONFIG=/home/me/my clusters/kubeconfig", "CLOUDSDK_AUTH_ACCESS_TOKEN=ya29.secret"},
+			},
+			want: `CLOUDSDK_CORE_PROJECT=proj KUBECONFIG="/home/me/my clusters/kubeconfig" CLOUDSDK_AUTH_ACCESS_TOKEN=<redacted> kubectl get pods`,
+		},
+		{
+			name: "dir and stdin",
+			p:    Process{RootCmd: "kubectl", Args: []string{"create", "-f", "-"}, Dir: "/tmp/demo", Stdin: []byte("kind: Secret\n")},
+			want: "kubectl create -f - (in /tmp/demo) (13 bytes on stdin)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ProcessLine(tt.p); got != tt.want {
+				t.Errorf("ProcessLine = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
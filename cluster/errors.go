@@ -0,0 +1,158 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors for well known gcloud, gsutil and kubectl failures. A
+// *CommandError matches them with errors.Is once its output is classified.
+var (
+	ErrAlreadyExists    = errors.New("resource already exists")
+	ErrNotFound         = errors.New("resource not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrAPINotEnabled    = errors.New("api not enabled")
+	ErrBillingDisabled  = errors.New("billing disabled")
+	ErrTransient        = errors.New("temporarily unavailable")
+)
+
+type errorClass struct {
+	err      error
+	patterns []string
+	hint     string
+}
+
+// errorClasses is checked in order, billing and disabled APIs first since
+// gcloud reports them with a PERMISSION_DENIED status as well. Rate limits
+// come before quota as some are reported as exceeding a per minute quota.
+var errorClasses = []errorClass{
+	{
+		err:      ErrBillingDisabled,
+		patterns: []string{"BILLING_DISABLED", "billing to be enabled", "billing account", "Billing account"},
+		hint:     "link a billing account to the project: gcloud beta billing projects link <project> --billing-account <account>",
+	},
+	{
+		err:      ErrAPINotEnabled,
+		patterns: []string{"SERVICE_DISABLED", "has not been used in project", "API has not been enabled", "is disabled. Enable it"},
+		hint:     "enable the api named in the error: gcloud services enable <service> --project <project>",
+	},
+	{
+		err: ErrTransient,
+		patterns: []string{
+			"RATE_LIMIT_EXCEEDED", "rateLimitExceeded", "Rate Limit Exceeded", "per minute", "code=429", "429 Too Many Requests",
+			"UNAVAILABLE", "backendError", "internalError", "code=500", "code=502", "code=503", "code=504",
+			"500 Internal Server Error", "502 Bad Gateway", "503 Service Unavailable", "504 Gateway Timeout",
+		},
+		hint: "the api is overloaded or rate limited; wait a minute and run the command again",
+	},
+	{
+		err:      ErrQuotaExceeded,
+		patterns: []string{"QUOTA_EXCEEDED", "RESOURCE_EXHAUSTED", "Quota exceeded", "quota exceeded", "Insufficient regional quota"},
+		hint:     "request a quota increase in the cloud console or pick another region, zone or machine type",
+	},
+	{
+		err:      ErrAlreadyExists,
+		patterns: []string{"ALREADY_EXISTS", "(AlreadyExists)", "already exists", "Already exists"},
+		hint:     "the resource was created before; delete it or rerun with 'kmanager create --resume <name>'",
+	},
+	{
+		err:      ErrNotFound,
+		patterns: []string{"NOT_FOUND", "(NotFound)", "was not found", "does not exist", "BucketNotFound", "code=404"},
+		hint:     "the resource is missing; check the project, zone and name it is looked up with",
+	},
+	{
+		err:      ErrPermissionDenied,
+		patterns: []string{"PERMISSION_DENIED", "(Forbidden)", "Permission denied", "does not have permission", "AccessDeniedException"},
+		hint:     "make sure the active gcloud account has the required IAM role: gcloud config list account",
+	},
+}
+
+// Classify returns the sentinel error matching output, the stderr of a
+// failed command, or nil when the failure is not recognized.
+func Classify(output string) error {
+	class := classify(output)
+	if class == nil {
+		return nil
+	}
+	return class.err
+}
+
+func classify(output string) *errorClass {
+	for i := range errorClasses {
+		for _, p := range errorClasses[i].patterns {
+			if strings.Contains(output, p) {
+				return &errorClasses[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Hint returns a one line remediation hint for err, or an empty string when
+// err is not a recognized failure.
+func Hint(err error) string {
+	for _, class := range errorClasses {
+		if errors.Is(err, class.err) {
+			return class.hint
+		}
+	}
+	return ""
+}
+
+// Explain formats err for the user. Recognized failures are summarized
+// with their remediation hint instead of the raw command output.
+func Explain(err error) string {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Kind != nil {
+		return fmt.Sprintf("%s: %v\n    hint: %s", cmdErr.Name, cmdErr.Kind, Hint(cmdErr.Kind))
+	}
+	return err.Error()
+}
+
+// CommandError describes a failed invocation of an external command. Kind
+// holds the classification of its stderr, if any.
+type CommandError struct {
+	Name     string
+	Argv     []string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Kind     error
+	Err      error
+}
+
+func newCommandError(p Process, res ProcessResult, err error) *CommandError {
+	return &CommandError{
+		Name:     p.Name,
+		Argv:     append([]string{p.RootCmd}, p.Args...),
+		ExitCode: res.ExitCode,
+		Stdout:   res.Stdout,
+		Stderr:   res.Stderr,
+		Kind:     Classify(res.Stderr),
+		Err:      err,
+	}
+}
+
+func (e *CommandError) Error() string {
+	var msg string
+	if e.ExitCode > 0 {
+		msg = fmt.Sprintf("%s: %s exited with status %d", e.Name, e.Argv[0], e.ExitCode)
+	} else {
+		msg = fmt.Sprintf("%s: %s: %v", e.Name, e.Argv[0], e.Err)
+	}
+
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += "\n" + stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   error
+	}{
+		{"empty", "", nil},
+		{"unrecognized", "ERROR: (gcloud.container.clusters.create) something odd happened", nil},
+		{"already exists", "ERROR: (gcloud.dns.managed-zones.create) ALREADY_EXISTS: demo", ErrAlreadyExists},
+		{"kubectl already exists", `Error from server (AlreadyExists): secrets "demo" already exists`, ErrAlreadyExists},
+		{"gsutil bucket exists", "ServiceException: 409 Bucket demo-sourcecode already exists.", ErrAlreadyExists},
+		{"not found", "ERROR: (gcloud.container.clusters.delete) NOT_FOUND: cluster demo", ErrNotFound},
+		{"bucket not found", "BucketNotFoundException: 404 gs://demo-sourcecode bucket does not exist.", ErrNotFound},
+		{"permission denied", "ERROR: (gcloud.iam.service-accounts.create) PERMISSION_DENIED: Permission iam.serviceAccounts.create denied", ErrPermissionDenied},
+		{"gsutil access denied", "AccessDeniedException: 403 me@example.com does not have storage.buckets.create access", ErrPermissionDenied},
+		{"quota", "ERROR: (gcloud.container.clusters.create) ResponseError: code=403, message=Insufficient regional quota to satisfy request", ErrQuotaExceeded},
+		{"api disabled reported as permission denied", "ERROR: PERMISSION_DENIED: Kubernetes Engine API has not been used in project 123 before or it is disabled. SERVICE_DISABLED", ErrAPINotEnabled},
+		{"rate limit", "ERROR: (gcloud.projects.add-iam-policy-binding) RATE_LIMIT_EXCEEDED: too many requests", ErrTransient},
+		{"server error", "ERROR: (gcloud.container.clusters.create) ResponseError: code=503, message=try again", ErrTransient},
+		{"billing disabled reported as permission denied", "ERROR: PERMISSION_DENIED: This API method requires billing to be enabled. BILLING_DISABLED", ErrBillingDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.stderr); got != tt.want {
+				t.Errorf("Classify(%q) = %v, want %v", tt.stderr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	tests := []struct {
+		name    string
+		res     ProcessResult
+		err     error
+		kind    error
+		message string
+		explain string
+	}{
+		{
+			name:    "classified",
+			res:     ProcessResult{ExitCode: 1, Stderr: "ERROR: (gcloud.dns.managed-zones.create) ALREADY_EXISTS: demo\n"},
+			err:     errors.New("exit status 1"),
+			kind:    ErrAlreadyExists,
+			message: "create-dns-zone: gcloud exited with status 1\nERROR: (gcloud.dns.managed-zones.create) ALREADY_EXISTS: demo",
+			explain: "create-dns-zone: resource already exists\n    hint: " + Hint(ErrAlreadyExists),
+		},
+		{
+			name:    "unclassified",
+			res:     ProcessResult{ExitCode: 2, Stderr: "ERROR: unexpected"},
+			err:     errors.New("exit status 2"),
+			message: "create-dns-zone: gcloud exited with status 2\nERROR: unexpected",
+			explain: "create-dns-zone: gcloud exited with status 2\nERROR: unexpected",
+		},
+		{
+			name:    "not started",
+			res:     ProcessResult{ExitCode: -1},
+			err:     errors.New(`exec: "gcloud": executable file not found in $PATH`),
+			message: `create-dns-zone: gcloud: exec: "gcloud": executable file not found in $PATH`,
+			explain: `create-dns-zone: gcloud: exec: "gcloud": executable file not found in $PATH`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Process{Name: "create-dns-zone", RootCmd: "gcloud", Args: []string{"dns", "managed-zones", "create", "demo"}}
+			err := fmt.Errorf("wrapped: %w", newCommandError(p, tt.res, tt.err))
+
+			var cmdErr *CommandError
+			if !errors.As(err, &cmdErr) {
+				t.Fatalf("%v is no CommandError", err)
+			}
+			if cmdErr.Error() != tt.message {
+				t.Errorf("Error = %q, want %q", cmdErr.Error(), tt.message)
+			}
+			if got := Explain(err); !strings.HasSuffix(got, tt.explain) {
+				t.Errorf("Explain = %q, want %q", got, tt.explain)
+			}
+			if tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.kind)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%v does not unwrap to %v", err, tt.err)
+			}
+			for _, kind := range []error{ErrNotFound, ErrPermissionDenied} {
+				if errors.Is(err, kind) {
+					t.Errorf("errors.Is(%v, %v) = true", err, kind)
+				}
+			}
+		})
+	}
+}
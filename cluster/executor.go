@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Process describes a single invocation of an external program such as
+// gcloud, gsutil or kubectl. ReadOnly processes only query state.
+type Process struct {
+	Name    string
+	RootCmd string
+	Args    []string
+	// Env is added to the environment of the process, as KEY=value.
+	Env []string
+	// Dir is the working directory of the process, the current one if empty.
+	Dir string
+	// Stdin, when not nil, is fed to the process instead of the terminal.
+	Stdin       []byte
+	InterActive bool
+	ReadOnly    bool
+}
+
+// ProcessResult holds the captured output of a finished Process.
+type ProcessResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Executor runs processes on behalf of Command. A non-nil error is returned
+// when the process could not be started or exited with a non-zero code.
+type Executor interface {
+	Exec(ctx context.Context, p Process) (ProcessResult, error)
+}
+
+// DefaultExecutor is used by commands which have no executor injected.
+var DefaultExecutor Executor = OSExecutor{}
+
+// OSExecutor runs processes on the local machine using os/exec.
+type OSExecutor struct{}
+
+func (OSExecutor) Exec(ctx context.Context, p Process) (ProcessResult, error) {
+	var res ProcessResult
+	cmd := exec.CommandContext(ctx, p.RootCmd, p.Args...)
+	if len(p.Env) > 0 {
+		cmd.Env = append(os.Environ(), p.Env...)
+	}
+	cmd.Dir = p.Dir
+	if p.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(p.Stdin)
+	}
+
+	if p.InterActive {
+		cmd.Stderr = os.Stderr
+		cmd.Stdout = os.Stdout
+		if p.Stdin == nil {
+			cmd.Stdin = os.Stdin
+		}
+
+		err := cmd.Run()
+		res.ExitCode = exitCode(err)
+		return res, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	res.ExitCode = exitCode(err)
+	return res, err
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
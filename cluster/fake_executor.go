@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeResponse is a canned result returned by FakeExecutor for every process
+// whose RootCmd and Args match. A nil Args matches any arguments.
+type FakeResponse struct {
+	RootCmd  string
+	Args     []string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Err      error
+}
+
+func (r FakeResponse) matches(p Process) bool {
+	if r.RootCmd != p.RootCmd {
+		return false
+	}
+	if r.Args == nil {
+		return true
+	}
+	if len(r.Args) != len(p.Args) {
+		return false
+	}
+	for i := range r.Args {
+		if r.Args[i] != p.Args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FakeExecutor is a scripted Executor for exercising commands without the
+// real cloud CLIs. Responses are consumed in order; once every matching
+// response has been used the last one keeps being returned. Every process
+// it is asked to run is recorded in Calls.
+type FakeExecutor struct {
+	mu        sync.Mutex
+	Responses []FakeResponse
+	Calls     []Process
+	used      map[int]bool
+}
+
+// NewFakeExecutor returns a FakeExecutor scripted with the given responses.
+func NewFakeExecutor(responses ...FakeResponse) *FakeExecutor {
+	return &FakeExecutor{Responses: responses}
+}
+
+// Add appends a response to the script.
+func (f *FakeExecutor) Add(r FakeResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Responses = append(f.Responses, r)
+}
+
+func (f *FakeExecutor) Exec(ctx context.Context, p Process) (ProcessResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.used == nil {
+		f.used = make(map[int]bool)
+	}
+	f.Calls = append(f.Calls, p)
+
+	last := -1
+	for i, r := range f.Responses {
+		if !r.matches(p) {
+			continue
+		}
+		last = i
+		if !f.used[i] {
+			break
+		}
+	}
+	if last == -1 {
+		return ProcessResult{ExitCode: -1}, fmt.Errorf("fake executor: unexpected command %s %s", p.RootCmd, strings.Join(p.Args, " "))
+	}
+	f.used[last] = true
+
+	r := f.Responses[last]
+	res := ProcessResult{Stdout: r.Stdout, Stderr: r.Stderr, ExitCode: r.ExitCode}
+	if r.Err != nil {
+		return res, r.Err
+	}
+	if r.ExitCode != 0 {
+		return res, fmt.Errorf("exit status %d", r.ExitCode)
+	}
+	return res, nil
+}
+
+// Called reports how many times a process with the given RootCmd and
+// leading arguments has been run.
+func (f *FakeExecutor) Called(rootCmd string, args ...string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, p := range f.Calls {
+		if p.RootCmd != rootCmd || len(p.Args) < len(args) {
+			continue
+		}
+		match := true
+		for i := range args {
+			if p.Args[i] != args[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/AlecAivazis/survey/v2/terminal"
 	"github.com/fatih/color"
 	"github.com/urvil38/kmanager/questions"
 )
@@ -47,10 +48,11 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 
 	cmds := []Command{
 		{
-			Name:    "check-gcloud-login",
-			RootCmd: "gcloud",
-			Args:    []string{"config", "list", "--format", "json"},
-			AfterFn: func(cmd *Command) error {
+			Name:     "check-gcloud-login",
+			RootCmd:  "gcloud",
+			ReadOnly: true,
+			Args:     []string{"config", "list", "--format", "json"},
+			AfterFn: func(ctx context.Context, cmd *Command) error {
 				if cmd.Succeed {
 					var ga GcloudAccount
 					err := json.NewDecoder(strings.NewReader(cmd.Stdout)).Decode(&ga)
@@ -58,36 +60,55 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 						return err
 					}
 					if ga.Core.Account == "" {
+						if c.NonInteractive {
+							return errors.New("no active gcloud account, run 'gcloud auth login' first")
+						}
 						loginCmd, err := gcloudCmds.GetCommand("gcloud-login")
 						if err != nil {
 							return err
 						}
-						loginCmd.Execute(context.Background(), c)
+						loginCmd.Execute(ctx, c)
 						if !loginCmd.Succeed {
 							return loginCmd.Stderr
 						}
 					}
-					if ga.Compute.Region != "" {
+					switch {
+					case c.Region != "":
+						// given up front, e.g. by a spec file
+					case ga.Compute.Region != "":
 						c.Region = ga.Compute.Region
-					} else {
-						region, err := selectRegion()
+					case c.NonInteractive:
+						return errors.New("no region given and no default region set in gcloud config")
+					default:
+						region, err := selectRegion(ctx, c)
 						if err != nil {
 							return err
 						}
 						c.Region = region
 					}
-					if ga.Compute.Zone != "" {
+					switch {
+					case c.Regional():
+						// the control plane spans the whole region
+					case c.Zone != "":
+						// given up front, e.g. by a spec file
+					case ga.Compute.Zone != "":
 						c.Zone = ga.Compute.Zone
-					} else {
-						zone, err := selectZone(c.Region)
+					case c.NonInteractive:
+						return errors.New("no zone given and no default zone set in gcloud config")
+					default:
+						zone, err := selectZone(ctx, c, c.Region)
 						if err != nil {
 							return err
 						}
 						c.Zone = zone
 					}
-					if ga.Core.Account != "" {
+					if ga.Core.Account != "" && c.Account == "" {
 						c.Account = ga.Core.Account
 					}
+					err = c.ValidateLocation()
+					if err != nil {
+						return err
+					}
 				} else {
 					return cmd.Stderr
 				}
@@ -102,10 +123,12 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 			InterActive: true,
 		},
 		{
-			Name:    "list-gcloud-accounts",
-			RootCmd: "gcloud",
-			Args:    []string{"projects", "list", "--filter", "lifecycleState:ACTIVE", "--format", "json"},
-			AfterFn: func(cmd *Command) error {
+			Name:      "list-gcloud-accounts",
+			RootCmd:   "gcloud",
+			ReadOnly:  true,
+			DependsOn: []string{"check-gcloud-login"},
+			Args:      []string{"projects", "list", "--filter", "lifecycleState:ACTIVE", "--format", "json"},
+			AfterFn: func(ctx context.Context, cmd *Command) error {
 				if cmd.Succeed {
 					var pl ProjectList
 					err := json.NewDecoder(strings.NewReader(cmd.Stdout)).Decode(&pl)
@@ -113,6 +136,24 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 						return err
 					}
 
+					if c.GcloudProjectName != "" {
+						found := false
+						for _, p := range pl {
+							if p.ProjectID == c.GcloudProjectName {
+								found = true
+							}
+						}
+						if !found {
+							return fmt.Errorf("project %q is not among the active projects of the gcloud account", c.GcloudProjectName)
+						}
+						c.GetServiceAccountOpts()
+						return nil
+					}
+
+					if c.NonInteractive {
+						return errors.New("no gcloud project given")
+					}
+
 					var projectsOpts []string
 
 					for _, p := range pl {
@@ -126,6 +167,8 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 						ci := strings.Index(selectedProj, `)`)
 						if oi != -1 && ci != -1 && oi < ci {
 							c.GcloudProjectName = selectedProj[oi+1 : ci]
+							c.GetStorageOpts()
+							c.GetServiceAccountOpts()
 						} else {
 							return errors.New("invalid project name")
 						}
@@ -139,20 +182,9 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 			},
 		},
 		{
-			Name:    "list-dns-server",
-			RootCmd: "gcloud",
-			GenerateArgs: func(c *Cluster) []string {
-				return []string{
-					"dns", "record-sets", "list",
-					"--zone", c.Name,
-					"--format", "json",
-				}
-			},
-			Internal: true,
-		},
-		{
-			Name:    "create-dns-zone",
-			RootCmd: "gcloud",
+			Name:      "create-dns-zone",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"list-gcloud-accounts"},
 			GenerateArgs: func(c *Cluster) []string {
 				return []string{
 					"dns",
@@ -161,32 +193,42 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 					"--dns-name", c.DNSName,
 					"--project", c.GcloudProjectName,
 					"--description", "kubepaas managed zone",
+					"--labels", joinLabels(c.ResourceLabels()),
 				}
 			},
-			AfterFn: func(cmd *Command) error {
-				dnsListCmd, err := gcloudCmds.GetCommand("list-dns-server")
-				if err != nil {
-					return err
-				}
-				err = printDNSServers(dnsListCmd, c)
-				if err != nil {
-					return err
-				}
-				return nil
+		},
+		{
+			Name:      "create-storage-bucket-soucecode",
+			RootCmd:   "gsutil",
+			DependsOn: []string{"list-gcloud-accounts"},
+			GenerateArgs: func(c *Cluster) []string {
+				return []string{"mb", "-l", c.Region, "gs://" + c.Storage.SourceCodeBucket}
 			},
 		},
 		{
-			Name:    "create-storage-bucket-soucecode",
-			RootCmd: "gsutil",
+			Name:      "label-storage-bucket-soucecode",
+			RootCmd:   "gsutil",
+			DependsOn: []string{"create-storage-bucket-soucecode"},
+			Retry:     BucketRetryPolicy,
 			GenerateArgs: func(c *Cluster) []string {
-				return []string{"mb", "-l", c.Region, "gs://" + c.GetStorageOpts().SourceCodeBucket}
+				return gsutilLabelArgs(c.ResourceLabels(), c.Storage.SourceCodeBucket)
 			},
 		},
 		{
-			Name:    "create-storage-bucket-cloudbuild-logs",
-			RootCmd: "gsutil",
+			Name:      "create-storage-bucket-cloudbuild-logs",
+			RootCmd:   "gsutil",
+			DependsOn: []string{"list-gcloud-accounts"},
 			GenerateArgs: func(c *Cluster) []string {
-				return []string{"mb", "-l", c.Region, "gs://" + c.GetStorageOpts().CloudBuildBucket}
+				return []string{"mb", "-l", c.Region, "gs://" + c.Storage.CloudBuildBucket}
+			},
+		},
+		{
+			Name:      "label-storage-bucket-cloudbuild-logs",
+			RootCmd:   "gsutil",
+			DependsOn: []string{"create-storage-bucket-cloudbuild-logs"},
+			Retry:     BucketRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return gsutilLabelArgs(c.ResourceLabels(), c.Storage.CloudBuildBucket)
 			},
 		},
 	}
@@ -198,10 +240,11 @@ func (c *Cluster) InitGCloudCmdSet() (*CmdSet, error) {
 	return gcloudCmds, nil
 }
 
-func selectRegion() (string, error) {
+func selectRegion(ctx context.Context, c *Cluster) (string, error) {
 	cmd := Command{
-		Name:    "list-region",
-		RootCmd: "gcloud",
+		Name:     "list-region",
+		RootCmd:  "gcloud",
+		ReadOnly: true,
 		Args: []string{
 			"compute", "regions", "list",
 			"--format", "value(selfLink.scope())",
@@ -210,7 +253,7 @@ func selectRegion() (string, error) {
 
 	var options []string
 	var selectedRegion string
-	cmd.Execute(context.Background(), nil)
+	cmd.Execute(ctx, c)
 	if !cmd.Succeed {
 		return "", cmd.Stderr
 	}
@@ -224,10 +267,11 @@ func selectRegion() (string, error) {
 	return selectedRegion, nil
 }
 
-func selectZone(selectedRegion string) (string, error) {
+func selectZone(ctx context.Context, c *Cluster, selectedRegion string) (string, error) {
 	cmd := Command{
-		Name:    "list-zone",
-		RootCmd: "gcloud",
+		Name:     "list-zone",
+		RootCmd:  "gcloud",
+		ReadOnly: true,
 		Args: []string{
 			"compute", "zones", "list",
 			"--format", "value(selfLink.scope())",
@@ -238,7 +282,7 @@ func selectZone(selectedRegion string) (string, error) {
 
 	var options []string
 	var selectedZone string
-	cmd.Execute(context.Background(), nil)
+	cmd.Execute(ctx, c)
 	if !cmd.Succeed {
 		fmt.Print(cmd.Stderr)
 		os.Exit(1)
@@ -254,9 +298,22 @@ func selectZone(selectedRegion string) (string, error) {
 	return selectedZone, nil
 }
 
-func printDNSServers(dnsListCmd Command, c *Cluster) error {
-
-	dnsListCmd.Execute(context.Background(), c)
+// ConfirmDNSServers prints the name servers of the DNS zone of c and, unless
+// c is non-interactive, waits for the user to confirm they were configured
+// with the registrar. It is run after the creation steps, so the prompt is
+// not interleaved with the output of steps running in parallel.
+func (c *Cluster) ConfirmDNSServers(ctx context.Context) error {
+	dnsListCmd := Command{
+		Name:     "list-dns-server",
+		RootCmd:  "gcloud",
+		ReadOnly: true,
+		Args: []string{
+			"dns", "record-sets", "list",
+			"--zone", c.Name,
+			"--format", "json",
+		},
+	}
+	dnsListCmd.Execute(ctx, c)
 	if !dnsListCmd.Succeed {
 		return dnsListCmd.Stderr
 	}
@@ -280,34 +337,35 @@ func printDNSServers(dnsListCmd Command, c *Cluster) error {
 	color.HiYellow("This zone will not normally be usable until you register the related domain and configure following records with your registrar")
 	color.HiWhite(DNSServerAddrs)
 
+	if c.NonInteractive {
+		return nil
+	}
+
 	added := false
-	for {
-		err = survey.Ask(questions.DNSNameServerConfirmation, &added)
-		if err != nil {
-			fmt.Println(err)
+	for !added {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-
-		if added {
-			return nil
+		// survey reads Ctrl-C itself, so no SIGINT cancels ctx
+		err = survey.Ask(questions.DNSNameServerConfirmation, &added)
+		if errors.Is(err, terminal.InterruptErr) {
+			return errors.New("interrupted while waiting for the name servers to be configured")
+		} else if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func CreateServiceAccount(name string) error {
+func CreateServiceAccount(ctx context.Context, e Executor, name string, labels map[string]string) error {
 	cmd := Command{
-		Name:    "create-service-account",
-		RootCmd: "gcloud",
-		Args: []string{
-			"iam",
-			"service-accounts",
-			"create",
-			name,
-			"--display-name",
-			name,
-		},
+		Name:     "create-service-account",
+		RootCmd:  "gcloud",
+		Args:     createServiceAccountArgs(name, labels),
+		Executor: e,
 	}
 
-	cmd.Execute(context.Background(), nil)
+	cmd.Execute(ctx, nil)
 	if !cmd.Succeed {
 		return cmd.Stderr
 	}
@@ -315,17 +373,16 @@ func CreateServiceAccount(name string) error {
 	return nil
 }
 
-func BindServiceAccToBucket(serviceAccount, bucket, permission string) error {
+func BindServiceAccToBucket(ctx context.Context, e Executor, serviceAccount, bucket, permission string) error {
 	cmd := Command{
-		Name:    "bind-service-account-to-bucket",
-		RootCmd: "gsutil",
-		Args: []string{
-			"iam", "ch", "serviceAccount:" + serviceAccount + ":" + permission,
-			"gs://" + bucket,
-		},
+		Name:     "bind-service-account-to-bucket",
+		RootCmd:  "gsutil",
+		Args:     bindServiceAccToBucketArgs(serviceAccount, bucket, permission),
+		Executor: e,
+		Retry:    IAMRetryPolicy,
 	}
 
-	cmd.Execute(context.Background(), nil)
+	cmd.Execute(ctx, nil)
 	if !cmd.Succeed {
 		return cmd.Stderr
 	}
@@ -333,21 +390,16 @@ func BindServiceAccToBucket(serviceAccount, bucket, permission string) error {
 	return nil
 }
 
-func BindServiceAccountToRole(gcloudProject, serviceAccount, role string) error {
+func BindServiceAccountToRole(ctx context.Context, e Executor, gcloudProject, serviceAccount, role string) error {
 	cmd := Command{
-		Name:    "bind-service-account",
-		RootCmd: "gcloud",
-		Args: []string{
-			"projects",
-			"add-iam-policy-binding",
-			gcloudProject,
-			"--member",
-			"serviceAccount:" + serviceAccount,
-			"--role", role,
-		},
+		Name:     "bind-service-account",
+		RootCmd:  "gcloud",
+		Args:     bindServiceAccountToRoleArgs(gcloudProject, serviceAccount, role),
+		Executor: e,
+		Retry:    IAMRetryPolicy,
 	}
 
-	cmd.Execute(context.Background(), nil)
+	cmd.Execute(ctx, nil)
 	if !cmd.Succeed {
 		return cmd.Stderr
 	}
@@ -355,20 +407,59 @@ func BindServiceAccountToRole(gcloudProject, serviceAccount, role string) error
 	return nil
 }
 
-func GenerateServiceAccountKey(serviceAccount, path string) error {
+func GenerateServiceAccountKey(ctx context.Context, e Executor, serviceAccount, path string) error {
 	cmd := Command{
-		Name:    "generate-service-account-keys",
-		RootCmd: "gcloud",
-		Args: []string{
-			"iam", "service-accounts", "keys", "create",
-			"--iam-account", serviceAccount,
-			path,
-		},
+		Name:     "generate-service-account-keys",
+		RootCmd:  "gcloud",
+		Args:     generateServiceAccountKeyArgs(serviceAccount, path),
+		Executor: e,
+		Retry:    IAMRetryPolicy,
 	}
 
-	cmd.Execute(context.Background(), nil)
+	cmd.Execute(ctx, nil)
 	if !cmd.Succeed {
 		return cmd.Stderr
 	}
 	return nil
 }
+
+// createServiceAccountArgs puts labels into the description, as service
+// accounts can't carry labels.
+func createServiceAccountArgs(name string, labels map[string]string) []string {
+	return []string{
+		"iam",
+		"service-accounts",
+		"create",
+		name,
+		"--display-name",
+		name,
+		"--description",
+		joinLabels(labels),
+	}
+}
+
+func bindServiceAccToBucketArgs(serviceAccount, bucket, permission string) []string {
+	return []string{
+		"iam", "ch", "serviceAccount:" + serviceAccount + ":" + permission,
+		"gs://" + bucket,
+	}
+}
+
+func bindServiceAccountToRoleArgs(gcloudProject, serviceAccount, role string) []string {
+	return []string{
+		"projects",
+		"add-iam-policy-binding",
+		gcloudProject,
+		"--member",
+		"serviceAccount:" + serviceAccount,
+		"--role", role,
+	}
+}
+
+func generateServiceAccountKeyArgs(serviceAccount, path string) []string {
+	return []string{
+		"iam", "service-accounts", "keys", "create",
+		"--iam-account", serviceAccount,
+		path,
+	}
+}
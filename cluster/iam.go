@@ -0,0 +1,166 @@
+package cluster
+
+// InitIAMCmdSet returns the commands creating the service accounts used by
+// cert-manager, the generator and cloudbuild, binding them to their roles
+// and buckets and either generating their keys or, with Workload Identity,
+// letting the Kubernetes service accounts act as them. The commands depend on the gcloud
+// command set, so they are meant to be run merged with it.
+func (c *Cluster) InitIAMCmdSet() (*CmdSet, error) {
+	iamCmds := NewCmdSet(c, "iam")
+
+	cmds := []Command{
+		{
+			Name:      "create-clouddns-service-account",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"list-gcloud-accounts"},
+			GenerateArgs: func(c *Cluster) []string {
+				return createServiceAccountArgs(c.ServiceAccount.DNSName, c.ResourceLabels())
+			},
+		},
+		{
+			Name:      "bind-clouddns-service-account-role",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-clouddns-service-account"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return bindServiceAccountToRoleArgs(c.GcloudProjectName, c.ServiceAccount.DNS, "roles/dns.admin")
+			},
+		},
+		{
+			Name:      "create-storage-service-account",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"list-gcloud-accounts"},
+			GenerateArgs: func(c *Cluster) []string {
+				return createServiceAccountArgs(c.ServiceAccount.StorageName, c.ResourceLabels())
+			},
+		},
+		{
+			Name:      "bind-storage-service-account-sourcecode",
+			RootCmd:   "gsutil",
+			DependsOn: []string{"create-storage-service-account", "create-storage-bucket-soucecode"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return bindServiceAccToBucketArgs(c.ServiceAccount.Storage, c.Storage.SourceCodeBucket, "objectCreator")
+			},
+		},
+		{
+			Name:      "bind-storage-service-account-cloudbuild-logs",
+			RootCmd:   "gsutil",
+			DependsOn: []string{"create-storage-service-account", "create-storage-bucket-cloudbuild-logs"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return bindServiceAccToBucketArgs(c.ServiceAccount.Storage, c.Storage.CloudBuildBucket, "objectViewer")
+			},
+		},
+		{
+			Name:      "create-cloudbuild-service-account",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"list-gcloud-accounts"},
+			GenerateArgs: func(c *Cluster) []string {
+				return createServiceAccountArgs(c.ServiceAccount.CloudBuildName, c.ResourceLabels())
+			},
+		},
+		{
+			Name:      "bind-cloudbuild-service-account-role",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-cloudbuild-service-account"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return bindServiceAccountToRoleArgs(c.GcloudProjectName, c.ServiceAccount.CloudBuild, "roles/cloudbuild.builds.editor")
+			},
+		},
+	}
+
+	if c.WorkloadIdentity {
+		cmds = append(cmds, workloadIdentityCmds()...)
+	} else {
+		cmds = append(cmds, keyCmds(c)...)
+	}
+
+	for _, cmd := range cmds {
+		_ = iamCmds.AddCmd(cmd)
+	}
+
+	return iamCmds, nil
+}
+
+// keyCmds generate a JSON key for every service account into the config
+// dir of c, from where they are pushed into cluster secrets. The keys are
+// encrypted right after generating them when a passphrase is set. Their
+// paths are only known once the project is picked, which happens while the
+// commands run.
+func keyCmds(c *Cluster) []Command {
+	return []Command{
+		{
+			Name:      "generate-cloudbuild-service-account-key",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-cloudbuild-service-account"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return generateServiceAccountKeyArgs(c.ServiceAccount.CloudBuild, c.KeyPath(c.ServiceAccount.CloudBuildName))
+			},
+			AfterFn: sealKeyAfter(func() string {
+				return c.KeyPath(c.ServiceAccount.CloudBuildName)
+			}),
+		},
+		{
+			Name:      "generate-storage-service-account-key",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-storage-service-account"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return generateServiceAccountKeyArgs(c.ServiceAccount.Storage, c.KeyPath(c.ServiceAccount.StorageName))
+			},
+			AfterFn: sealKeyAfter(func() string {
+				return c.KeyPath(c.ServiceAccount.StorageName)
+			}),
+		},
+		{
+			Name:      "generate-clouddns-service-account-key",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-clouddns-service-account"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return generateServiceAccountKeyArgs(c.ServiceAccount.DNS, c.KeyPath(c.ServiceAccount.DNSName))
+			},
+			AfterFn: sealKeyAfter(func() string {
+				return c.KeyPath(c.ServiceAccount.DNSName)
+			}),
+		},
+	}
+}
+
+// workloadIdentityCmds allow the Kubernetes service accounts to act as the
+// service accounts. Members of the workload pool can only be bound once the
+// pool exists, which it does after creating the cluster.
+func workloadIdentityCmds() []Command {
+	return []Command{
+		{
+			Name:      "bind-clouddns-service-account-workload-identity",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-clouddns-service-account", "create-kubernetes-cluster"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return bindWorkloadIdentityArgs(c.GcloudProjectName, c.WorkloadPool(), c.DNSKubeServiceAccount())
+			},
+		},
+		{
+			Name:      "bind-storage-service-account-workload-identity",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-storage-service-account", "create-kubernetes-cluster"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return bindWorkloadIdentityArgs(c.GcloudProjectName, c.WorkloadPool(), c.StorageKubeServiceAccount())
+			},
+		},
+		{
+			Name:      "bind-cloudbuild-service-account-workload-identity",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"create-cloudbuild-service-account", "create-kubernetes-cluster"},
+			Retry:     IAMRetryPolicy,
+			GenerateArgs: func(c *Cluster) []string {
+				return bindWorkloadIdentityArgs(c.GcloudProjectName, c.WorkloadPool(), c.CloudBuildKubeServiceAccount())
+			},
+		},
+	}
+}
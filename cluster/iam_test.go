@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urvil38/kmanager/config"
+)
+
+// createResponses scripts the gcloud and gsutil calls of a successful
+// creation of the cluster demo in project proj.
+func createResponses() []FakeResponse {
+	return []FakeResponse{
+		{RootCmd: "gcloud", Args: []string{"config", "list", "--format", "json"}, Stdout: `{"core":{"account":"me@example.com"}}`},
+		{RootCmd: "gcloud", Args: []string{"projects", "list", "--filter", "lifecycleState:ACTIVE", "--format", "json"}, Stdout: `[{"name":"Proj","projectId":"proj"}]`},
+		{RootCmd: "gcloud", Args: []string{"dns", "record-sets", "list", "--zone", "demo", "--format", "json"}, Stdout: `[{"type":"NS","rrdatas":["ns1.example.com."]}]`},
+		{RootCmd: "gcloud"},
+		{RootCmd: "gsutil"},
+		{RootCmd: "kubectl"},
+	}
+}
+
+// setenv sets key to value for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	old, had := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kmanager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestKeyCmdsSealKeysOfProjectPickedWhileRunning(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+	}{
+		{"plaintext", ""},
+		{"encrypted", "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, config.PassphraseEnv, tt.passphrase)
+			setenv(t, config.KeyFileEnv, "")
+
+			dir := tempDir(t)
+			f := NewFakeExecutor(createResponses()...)
+			// the service accounts are only named once the project is
+			// known, as in an interactive create
+			c := &Cluster{
+				Name:              "demo",
+				DNSName:           "demo.example.com",
+				GcloudProjectName: "proj",
+				Region:            "us-central1",
+				Zone:              "us-central1-a",
+				LocationMode:      LocationZonal,
+				Nodes:             DefaultNodeConfig(),
+				ConfPath:          dir,
+				NonInteractive:    true,
+				Exec:              f,
+			}
+			c.GetStorageOpts()
+
+			names := []string{"demo-cloudbuild", "demo-storage", "demo-cert-clouddns"}
+			for _, name := range names {
+				// written by 'gcloud iam service-accounts keys create'
+				err := ioutil.WriteFile(filepath.Join(dir, name+".json"), []byte(`{"type":"service_account"}`), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gCmds, _ := c.InitGCloudCmdSet()
+			iamCmds, _ := c.InitIAMCmdSet()
+			cs := NewCmdSet(c, "create")
+			if err := cs.AddCmdSet(gCmds); err != nil {
+				t.Fatal(err)
+			}
+			if err := cs.AddCmdSet(iamCmds); err != nil {
+				t.Fatal(err)
+			}
+
+			err := cs.Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+
+			for _, name := range names {
+				path := filepath.Join(dir, name+".json")
+				if n := f.Called("gcloud", "iam", "service-accounts", "keys", "create", "--iam-account", fmt.Sprintf(ServiceAccountFmt, name, "proj"), path); n != 1 {
+					t.Errorf("key of %s generated %d times into %s, want once", name, n, path)
+				}
+
+				if tt.passphrase == "" {
+					fi, err := os.Stat(path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if fi.Mode().Perm() != 0600 {
+						t.Errorf("%s has mode %v, want 0600", path, fi.Mode().Perm())
+					}
+					continue
+				}
+
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("plaintext %s left behind", path)
+				}
+				data, err := config.ReadSealed(path)
+				if err != nil {
+					t.Fatalf("ReadSealed(%s): %v", path, err)
+				}
+				if string(data) != `{"type":"service_account"}` {
+					t.Errorf("decrypted %s = %q", path, data)
+				}
+			}
+		})
+	}
+}
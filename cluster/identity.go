@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// WorkloadIdentityAnnotation binds a Kubernetes service account to the
+	// Google service account it acts as.
+	WorkloadIdentityAnnotation = "iam.gke.io/gcp-service-account"
+	workloadIdentityUserRole   = "roles/iam.workloadIdentityUser"
+
+	certManagerNamespace = "cert-manager"
+	generatorNamespace   = "generator"
+)
+
+// KubeServiceAccount is a Kubernetes service account acting as the Google
+// service account GSA through Workload Identity.
+type KubeServiceAccount struct {
+	Name      string
+	Namespace string
+	GSA       string
+}
+
+// Member returns the IAM member of the Kubernetes service account.
+func (k KubeServiceAccount) Member(workloadPool string) string {
+	return fmt.Sprintf("serviceAccount:%s[%s/%s]", workloadPool, k.Namespace, k.Name)
+}
+
+// WorkloadPool returns the Workload Identity pool of the project of c.
+func (c *Cluster) WorkloadPool() string {
+	return c.GcloudProjectName + ".svc.id.goog"
+}
+
+// DNSKubeServiceAccount is used by cert-manager to solve DNS01 challenges.
+// It is the service account of the cert-manager controller itself.
+func (c *Cluster) DNSKubeServiceAccount() KubeServiceAccount {
+	return KubeServiceAccount{Name: "cert-manager", Namespace: certManagerNamespace, GSA: c.ServiceAccount.DNS}
+}
+
+// CloudBuildKubeServiceAccount is used by the generator to submit builds.
+func (c *Cluster) CloudBuildKubeServiceAccount() KubeServiceAccount {
+	return KubeServiceAccount{Name: c.ServiceAccount.CloudBuildName, Namespace: generatorNamespace, GSA: c.ServiceAccount.CloudBuild}
+}
+
+// StorageKubeServiceAccount is used by the generator to upload source code.
+func (c *Cluster) StorageKubeServiceAccount() KubeServiceAccount {
+	return KubeServiceAccount{Name: c.ServiceAccount.StorageName, Namespace: generatorNamespace, GSA: c.ServiceAccount.Storage}
+}
+
+func bindWorkloadIdentityArgs(gcloudProject, workloadPool string, ksa KubeServiceAccount) []string {
+	return []string{
+		"iam", "service-accounts", "add-iam-policy-binding", ksa.GSA,
+		"--project", gcloudProject,
+		"--role", workloadIdentityUserRole,
+		"--member", ksa.Member(workloadPool),
+	}
+}
+
+// createKubeServiceAccount creates ksa, unless it exists already, and
+// annotates it with the Google service account it acts as.
+func (c *Cluster) createKubeServiceAccount(ctx context.Context, ksa KubeServiceAccount) error {
+	err := c.createNamespace(ctx, ksa.Namespace)
+	if err != nil {
+		return err
+	}
+
+	createCmd := Command{
+		Name:    "create-kubernetes-service-account",
+		RootCmd: "kubectl",
+		Args:    []string{"create", "serviceaccount", ksa.Name, "--namespace", ksa.Namespace},
+	}
+
+	createCmd.Execute(ctx, c)
+	if !createCmd.Succeed && !errors.Is(createCmd.Stderr, ErrAlreadyExists) {
+		return createCmd.Stderr
+	}
+
+	annotateCmd := Command{
+		Name:    "annotate-kubernetes-service-account",
+		RootCmd: "kubectl",
+		Args: []string{
+			"annotate", "serviceaccount", ksa.Name, "--namespace", ksa.Namespace, "--overwrite",
+			fmt.Sprintf("%s=%s", WorkloadIdentityAnnotation, ksa.GSA),
+		},
+	}
+
+	annotateCmd.Execute(ctx, c)
+	if !annotateCmd.Succeed {
+		return annotateCmd.Stderr
+	}
+	return nil
+}
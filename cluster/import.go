@@ -0,0 +1,286 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ImportOptions tells Import where to look for the GKE cluster. Empty
+// fields are filled in from the active gcloud configuration, or, for the
+// location and DNS name, from what is found.
+type ImportOptions struct {
+	Name     string
+	Project  string
+	Account  string
+	Location string
+	DNSName  string
+}
+
+// ImportReport lists what Import found of the resources kmanager creates
+// for a cluster and what it could not find.
+type ImportReport struct {
+	Found   []string
+	Missing []string
+}
+
+func (r *ImportReport) found(format string, a ...interface{}) {
+	r.Found = append(r.Found, fmt.Sprintf(format, a...))
+}
+
+func (r *ImportReport) missing(format string, a ...interface{}) {
+	r.Missing = append(r.Missing, fmt.Sprintf(format, a...))
+}
+
+// taintEffects maps the taint effects reported by the GKE API to the ones
+// taken by gcloud.
+var taintEffects = map[string]string{
+	"NO_SCHEDULE":        "NoSchedule",
+	"PREFER_NO_SCHEDULE": "PreferNoSchedule",
+	"NO_EXECUTE":         "NoExecute",
+}
+
+// Import rebuilds the config of an existing GKE cluster. The cluster itself
+// has to exist; its DNS zone, buckets and service accounts are looked up by
+// the names kmanager gives them and reported when missing. Lookups are run
+// through e.
+func Import(ctx context.Context, e Executor, o ImportOptions) (*Cluster, *ImportReport, error) {
+	c := &Cluster{Name: o.Name, GcloudProjectName: o.Project, Account: o.Account, DNSName: o.DNSName, Exec: e}
+	r := &ImportReport{}
+
+	if c.GcloudProjectName == "" || c.Account == "" {
+		var conf struct {
+			Core struct {
+				Account string `json:"account"`
+				Project string `json:"project"`
+			} `json:"core"`
+		}
+		err := c.queryJSON(ctx, &conf, "list-gcloud-config", "config", "list", "--format", "json")
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.GcloudProjectName == "" {
+			c.GcloudProjectName = conf.Core.Project
+		}
+		if c.Account == "" {
+			c.Account = conf.Core.Account
+		}
+	}
+	if c.GcloudProjectName == "" {
+		return nil, nil, errors.New("no gcloud project given and none is set in the gcloud config")
+	}
+
+	gke, err := c.findKubernetesCluster(ctx, o.Location)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = c.importKubernetesCluster(gke, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.GetStorageOpts()
+	c.GetServiceAccountOpts()
+
+	c.importDNSZone(ctx, r)
+	for _, b := range []string{c.Storage.SourceCodeBucket, c.Storage.CloudBuildBucket} {
+		_, err := c.query(ctx, "describe-storage-bucket", "gsutil", "ls", "-b", "gs://"+b)
+		if err != nil {
+			r.missing("storage bucket gs://%s: %s", b, errorSummary(err))
+			continue
+		}
+		r.found("storage bucket gs://%s", b)
+	}
+	for _, sa := range []string{c.ServiceAccount.DNS, c.ServiceAccount.CloudBuild, c.ServiceAccount.Storage} {
+		_, err := c.query(ctx, "describe-service-account", "gcloud", "iam", "service-accounts", "describe", sa, "--project", c.GcloudProjectName, "--format", "json")
+		if err != nil {
+			r.missing("service account %s: %s", sa, errorSummary(err))
+			continue
+		}
+		r.found("service account %s", sa)
+	}
+
+	if c.Account == "" {
+		r.missing("gcloud account: none is active, pass it explicitly")
+	}
+	if !c.WorkloadIdentity {
+		r.missing("service account keys: they can't be recovered, generate new ones to reinstall the kubeapps")
+	}
+	r.missing("kubeapps: which of them are installed is not recorded in the cluster")
+	return c, r, nil
+}
+
+func (c *Cluster) query(ctx context.Context, name, rootCmd string, args ...string) (string, error) {
+	cmd := Command{
+		Name:     name,
+		RootCmd:  rootCmd,
+		ReadOnly: true,
+		Args:     args,
+	}
+	cmd.Execute(ctx, c)
+	if !cmd.Succeed {
+		return "", cmd.Stderr
+	}
+	return cmd.Stdout, nil
+}
+
+func (c *Cluster) queryJSON(ctx context.Context, v interface{}, name string, args ...string) error {
+	out, err := c.query(ctx, name, "gcloud", args...)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal([]byte(out), v)
+	if err != nil {
+		return fmt.Errorf("%s: unexpected output: %v", name, err)
+	}
+	return nil
+}
+
+// findKubernetesCluster looks up the GKE cluster named like c in its
+// project, in location if given.
+func (c *Cluster) findKubernetesCluster(ctx context.Context, location string) (gkeCluster, error) {
+	var clusters []gkeCluster
+	err := c.queryJSON(ctx, &clusters, "list-kubernetes-clusters",
+		"container", "clusters", "list",
+		"--project", c.GcloudProjectName,
+		"--filter", "name="+c.Name,
+		"--format", "json",
+	)
+	if err != nil {
+		return gkeCluster{}, err
+	}
+
+	var matches []gkeCluster
+	var locations []string
+	for _, gke := range clusters {
+		if gke.Name == c.Name && (location == "" || gke.Location == location) {
+			matches = append(matches, gke)
+			locations = append(locations, gke.Location)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		if location != "" {
+			return gkeCluster{}, fmt.Errorf("no GKE cluster named \"%s\" found in %s of project %s", c.Name, location, c.GcloudProjectName)
+		}
+		return gkeCluster{}, fmt.Errorf("no GKE cluster named \"%s\" found in project %s", c.Name, c.GcloudProjectName)
+	case 1:
+		return matches[0], nil
+	}
+	return gkeCluster{}, fmt.Errorf("found GKE clusters named \"%s\" in %s, pick one with --location", c.Name, strings.Join(locations, ", "))
+}
+
+// importKubernetesCluster takes the location, node shape, node pools and
+// labels of c from gke. It fails when gke is labelled as belonging to
+// another kmanager cluster. Labels kmanager would reject are left out.
+func (c *Cluster) importKubernetesCluster(gke gkeCluster, r *ImportReport) error {
+	if owner, ok := gke.ResourceLabels[ClusterLabel]; ok && owner != strings.ToLower(c.Name) {
+		return fmt.Errorf("GKE cluster \"%s\" is labelled %s=%s, it belongs to another kmanager cluster", gke.Name, ClusterLabel, owner)
+	}
+
+	if strings.Count(gke.Location, "-") == 2 {
+		c.LocationMode = LocationZonal
+		c.Zone = gke.Location
+		c.Region = gke.Location[:strings.LastIndex(gke.Location, "-")]
+		if len(gke.Locations) > 1 {
+			c.NodeLocations = append([]string{}, gke.Locations...)
+			sort.Strings(c.NodeLocations)
+		}
+	} else {
+		c.LocationMode = LocationRegional
+		c.Region = gke.Location
+	}
+	r.found("GKE cluster %s in %s", gke.Name, gke.Location)
+
+	c.WorkloadIdentity = gke.WorkloadIdentityConfig != nil && gke.WorkloadIdentityConfig.WorkloadPool != ""
+
+	for k, v := range gke.ResourceLabels {
+		if k == ClusterLabel || k == ManagedByLabel {
+			continue
+		}
+		if err := ValidateLabels(map[string]string{k: v}); err != nil {
+			r.missing("label %s: %v, left out", k, err)
+			continue
+		}
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		c.Labels[k] = v
+	}
+
+	c.Nodes = DefaultNodeConfig()
+	defaultPool := false
+	for _, p := range gke.NodePools {
+		if p.Name != "default-pool" {
+			c.NodePools = append(c.NodePools, importNodePool(p))
+			r.found("node pool %s", p.Name)
+			continue
+		}
+
+		defaultPool = true
+		c.Nodes = NodeConfig{
+			MachineType: p.Config.MachineType,
+			NumNodes:    p.InitialNodeCount,
+			DiskSizeGB:  p.Config.DiskSizeGb,
+			DiskType:    p.Config.DiskType,
+			ImageType:   p.Config.ImageType,
+			Preemptible: p.Config.Preemptible,
+			Scopes:      p.Config.OauthScopes,
+		}
+	}
+	if !defaultPool {
+		r.missing("default node pool: recorded with the default node shape")
+	}
+	return nil
+}
+
+func importNodePool(p gkeNodePool) NodePool {
+	pool := NodePool{
+		Name:        p.Name,
+		MachineType: p.Config.MachineType,
+		NumNodes:    p.InitialNodeCount,
+		DiskSizeGB:  p.Config.DiskSizeGb,
+		Spot:        p.Config.Spot,
+		Preemptible: p.Config.Preemptible,
+		Labels:      p.Config.Labels,
+	}
+	if p.Autoscaling != nil && p.Autoscaling.Enabled {
+		pool.Autoscaling = &Autoscaling{MinNodes: p.Autoscaling.MinNodeCount, MaxNodes: p.Autoscaling.MaxNodeCount}
+	}
+	for _, t := range p.Config.Taints {
+		effect, ok := taintEffects[t.Effect]
+		if !ok {
+			effect = t.Effect
+		}
+		pool.Taints = append(pool.Taints, fmt.Sprintf("%s=%s:%s", t.Key, t.Value, effect))
+	}
+	return pool
+}
+
+// importDNSZone takes the DNS name of c from its zone. A DNS name given up
+// front has to match the zone.
+func (c *Cluster) importDNSZone(ctx context.Context, r *ImportReport) {
+	var zone struct {
+		DNSName string `json:"dnsName"`
+	}
+	err := c.queryJSON(ctx, &zone, "describe-dns-zone", "dns", "managed-zones", "describe", c.Name, "--project", c.GcloudProjectName, "--format", "json")
+	if err != nil {
+		r.missing("DNS zone %s: %s", c.Name, errorSummary(err))
+		if c.DNSName == "" {
+			r.missing("DNS name: no zone to take it from, pass it explicitly")
+		}
+		return
+	}
+
+	dnsName := strings.TrimSuffix(zone.DNSName, ".")
+	if c.DNSName != "" && strings.TrimSuffix(c.DNSName, ".") != dnsName {
+		r.missing("DNS zone %s: serves %s instead of %s", c.Name, dnsName, c.DNSName)
+		return
+	}
+	c.DNSName = dnsName
+	r.found("DNS zone %s for %s", c.Name, dnsName)
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportKubernetesClusterLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    map[string]string
+		missing int
+		wantErr string
+	}{
+		{
+			name: "unlabelled",
+		},
+		{
+			name:   "own labels",
+			labels: map[string]string{ClusterLabel: "demo", ManagedByLabel: managedByValue, "team": "web"},
+			want:   map[string]string{"team": "web"},
+		},
+		{
+			name:    "invalid labels left out",
+			labels:  map[string]string{"team": "web", "Cost-Center": "a", "env": "Prod"},
+			want:    map[string]string{"team": "web"},
+			missing: 2,
+		},
+		{
+			name:    "other cluster",
+			labels:  map[string]string{ClusterLabel: "other", ManagedByLabel: managedByValue},
+			wantErr: "belongs to another kmanager cluster",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Cluster{Name: "demo"}
+			r := &ImportReport{}
+			gke := gkeCluster{
+				Name:           "demo",
+				Location:       "us-central1-a",
+				ResourceLabels: tt.labels,
+				NodePools:      []gkeNodePool{{Name: "default-pool"}},
+			}
+
+			err := c.importKubernetesCluster(gke, r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("importKubernetesCluster = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Labels, tt.want) {
+				t.Errorf("Labels = %v, want %v", c.Labels, tt.want)
+			}
+			if err := ValidateLabels(c.Labels); err != nil {
+				t.Errorf("imported labels are invalid: %v", err)
+			}
+			if len(r.Missing) != tt.missing {
+				t.Errorf("reported missing %v, want %d entries", r.Missing, tt.missing)
+			}
+		})
+	}
+}
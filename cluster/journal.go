@@ -0,0 +1,145 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JournalFile is the name of the journal kept next to config.json.
+const JournalFile = "journal.json"
+
+const (
+	StepDone        = "done"
+	StepFailed      = "failed"
+	StepInterrupted = "interrupted"
+	// StepAdopted marks a step whose resource already existed when a
+	// resumed run got to it. It counts as done, but as the resource may not
+	// have been created by kmanager it is never rolled back.
+	StepAdopted = "adopted"
+)
+
+// JournalEntry records the outcome of a single step of cluster creation.
+type JournalEntry struct {
+	Step   string    `json:"step"`
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Journal is the persisted list of steps run while creating a cluster. It
+// lets an interrupted creation be resumed from the first unfinished step.
+type Journal struct {
+	Entries []JournalEntry `json:"entries"`
+
+	mu   sync.Mutex
+	path string
+}
+
+// NewJournal returns an empty journal stored in dir, replacing any journal
+// left there by an earlier run.
+func NewJournal(dir string) *Journal {
+	return &Journal{path: filepath.Join(dir, JournalFile)}
+}
+
+// LoadJournal reads the journal stored in dir. A missing journal is
+// returned empty.
+func LoadJournal(dir string) (*Journal, error) {
+	j := NewJournal(dir)
+
+	b, err := ioutil.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, j)
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Done reports whether step has completed successfully or was adopted.
+func (j *Journal) Done(step string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	done := false
+	for _, e := range j.Entries {
+		if e.Step == step {
+			done = e.Status == StepDone || e.Status == StepAdopted
+		}
+	}
+	return done
+}
+
+// Record appends the outcome of step and writes the journal to disk.
+func (j *Journal) Record(step, status string, stepErr error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e := JournalEntry{
+		Step:   step,
+		Status: status,
+		Time:   time.Now().UTC(),
+	}
+	if stepErr != nil {
+		e.Error = stepErr.Error()
+	}
+	j.Entries = append(j.Entries, e)
+
+	b, err := json.MarshalIndent(j, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(j.path, b, 0600)
+}
+
+// Completed returns the steps which completed successfully, in the order
+// they finished. Adopted steps are left out, their resources were not
+// created by kmanager.
+func (j *Journal) Completed() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var steps []string
+	seen := make(map[string]bool)
+	for _, e := range j.Entries {
+		if e.Status == StepDone && !seen[e.Step] {
+			seen[e.Step] = true
+			steps = append(steps, e.Step)
+		}
+	}
+	return steps
+}
+
+// Interrupted returns the steps whose last recorded outcome is an
+// interruption, so their resources may or may not exist.
+func (j *Journal) Interrupted() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	last := make(map[string]string)
+	var order []string
+	for _, e := range j.Entries {
+		if _, isthere := last[e.Step]; !isthere {
+			order = append(order, e.Step)
+		}
+		last[e.Step] = e.Status
+	}
+
+	var steps []string
+	for _, step := range order {
+		if last[step] == StepInterrupted {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
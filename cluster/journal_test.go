@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	type record struct{ step, status string }
+	tests := []struct {
+		name        string
+		records     []record
+		done        []string
+		notDone     []string
+		completed   []string
+		interrupted []string
+	}{
+		{
+			name:    "empty",
+			notDone: []string{"a"},
+		},
+		{
+			name:      "done and failed",
+			records:   []record{{"a", StepDone}, {"b", StepFailed}},
+			done:      []string{"a"},
+			notDone:   []string{"b", "c"},
+			completed: []string{"a"},
+		},
+		{
+			name:      "last outcome wins",
+			records:   []record{{"a", StepFailed}, {"b", StepDone}, {"a", StepDone}, {"b", StepFailed}},
+			done:      []string{"a"},
+			notDone:   []string{"b"},
+			completed: []string{"b", "a"},
+		},
+		{
+			name:      "adopted steps are done but not completed",
+			records:   []record{{"a", StepDone}, {"b", StepAdopted}},
+			done:      []string{"a", "b"},
+			completed: []string{"a"},
+		},
+		{
+			name:        "interrupted until rerun",
+			records:     []record{{"a", StepInterrupted}, {"b", StepInterrupted}, {"a", StepDone}},
+			done:        []string{"a"},
+			notDone:     []string{"b"},
+			completed:   []string{"a"},
+			interrupted: []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			j := NewJournal(dir)
+			for _, r := range tt.records {
+				if err := j.Record(r.step, r.status, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			loaded, err := LoadJournal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, j := range []*Journal{j, loaded} {
+				for _, step := range tt.done {
+					if !j.Done(step) {
+						t.Errorf("Done(%s) = false", step)
+					}
+				}
+				for _, step := range tt.notDone {
+					if j.Done(step) {
+						t.Errorf("Done(%s) = true", step)
+					}
+				}
+				if got := j.Completed(); !reflect.DeepEqual(got, tt.completed) {
+					t.Errorf("Completed = %v, want %v", got, tt.completed)
+				}
+				if got := j.Interrupted(); !reflect.DeepEqual(got, tt.interrupted) {
+					t.Errorf("Interrupted = %v, want %v", got, tt.interrupted)
+				}
+			}
+		})
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	dir := tempDir(t)
+	f := NewFakeExecutor(
+		FakeResponse{RootCmd: "gcloud", Args: []string{"create", "b"}, ExitCode: 1, Stderr: "ERROR: (gcloud) backend error"},
+		FakeResponse{RootCmd: "gcloud"},
+	)
+	c := &Cluster{Name: "demo", Exec: f, ConfPath: dir, Journal: NewJournal(dir)}
+
+	newCmdSet := func() *CmdSet {
+		cs := NewCmdSet(c, "create")
+		cs.AddCmd(Command{Name: "create-a", RootCmd: "gcloud", Args: []string{"create", "a"}})
+		cs.AddCmd(Command{Name: "create-b", RootCmd: "gcloud", Args: []string{"create", "b"}, DependsOn: []string{"create-a"}})
+		cs.AddCmd(Command{Name: "create-c", RootCmd: "gcloud", Args: []string{"create", "c"}, DependsOn: []string{"create-b"}})
+		return cs
+	}
+
+	var runErr *RunError
+	if err := newCmdSet().Run(context.Background()); !errors.As(err, &runErr) {
+		t.Fatalf("first Run = %v, want a RunError", err)
+	}
+
+	var err error
+	c.Journal, err = LoadJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = NewFakeExecutor(FakeResponse{RootCmd: "gcloud"})
+	c.Exec = f
+	if err := newCmdSet().Run(context.Background()); err != nil {
+		t.Fatalf("resumed Run: %v", err)
+	}
+
+	for step, want := range map[string]int{"a": 0, "b": 1, "c": 1} {
+		if n := f.Called("gcloud", "create", step); n != want {
+			t.Errorf("resumed run created %s %d times, want %d", step, n, want)
+		}
+	}
+	if got := c.Journal.Completed(); !reflect.DeepEqual(got, []string{"create-a", "create-b", "create-c"}) {
+		t.Errorf("Completed = %v", got)
+	}
+}
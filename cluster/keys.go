@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urvil38/kmanager/config"
+)
+
+// KeyPath is where the key of the service account named name is generated
+// into. With a passphrase set it is kept encrypted at KeyPath plus
+// config.EncryptedExt.
+func (c *Cluster) KeyPath(name string) string {
+	return filepath.Join(c.ConfPath, name+".json")
+}
+
+// sealKeyAfter returns an AfterFn encrypting the key generated at the
+// path returned by keyPath when the command has run.
+func sealKeyAfter(keyPath func() string) func(context.Context, *Command) error {
+	return func(ctx context.Context, cmd *Command) error {
+		if !cmd.Succeed {
+			return nil
+		}
+		return sealKey(keyPath())
+	}
+}
+
+// sealKey encrypts the key at path when a passphrase is set. Without one the
+// key is left in plaintext, readable by the current user only.
+func sealKey(path string) error {
+	passphrase, err := config.Passphrase()
+	if errors.Is(err, config.ErrNoPassphrase) {
+		fmt.Printf("warning: %s is stored unencrypted, export %s or %s to encrypt service account keys\n", path, config.PassphraseEnv, config.KeyFileEnv)
+		return os.Chmod(path, 0600)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = config.SealFile(path, passphrase)
+	return err
+}
@@ -2,10 +2,12 @@ package cluster
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"text/template"
 
 	"github.com/urvil38/kmanager/config"
@@ -13,10 +15,12 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
-func (c *Cluster) ConfigKubernetes() error {
+// ConfigKubernetes installs the kubeapps of the index on the cluster. A
+// failed kubeapp does not stop the others, but fails the whole.
+func (c *Cluster) ConfigKubernetes(ctx context.Context) error {
 	client := kh.NewHTTPClient(nil)
 
-	kmangerIndexReq, err := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/kmanager/index.yaml", nil)
+	kmangerIndexReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.KubeAppOptions.index(), nil)
 	if err != nil {
 		return err
 	}
@@ -37,16 +41,31 @@ func (c *Cluster) ConfigKubernetes() error {
 		return err
 	}
 
-	kConfigDir, err := config.CreateConfigDir(c.Name)
-	if err != nil {
-		return err
+	plan, dryRun := c.Exec.(*Plan)
+
+	kConfigDir := c.ConfPath
+	if !dryRun {
+		kConfigDir, err = config.CreateConfigDir(c.Name)
+		if err != nil {
+			return err
+		}
+
+		err = c.EnsureKubeconfig(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
+	var failed []string
 	for _, app := range c.KubeAppConfig.Apps {
-		if app.Deprecated {
+		if app.Deprecated || c.KubeAppOptions.excluded(app.Name) {
 			continue
 		}
 
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		if c.KubeAppMap == nil {
 			c.KubeAppMap = make(map[string]App)
 		}
@@ -56,7 +75,13 @@ func (c *Cluster) ConfigKubernetes() error {
 			c.KubeAppMap[app.Name] = app
 		}
 
-		req, err := http.NewRequest(http.MethodGet, app.Path, nil)
+		step := "kubeapp-" + app.Name
+		if c.Journal != nil && c.Journal.Done(step) {
+			fmt.Printf("%s: already done, skipping\n", step)
+			continue
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, app.Path, nil)
 		if err != nil {
 			return err
 		}
@@ -72,24 +97,43 @@ func (c *Cluster) ConfigKubernetes() error {
 			return err
 		}
 
-		cData, err := c.valueFromTemplate(app, string(b))
+		cData, err := c.valueFromTemplate(ctx, app, string(b))
 		if err != nil {
 			fmt.Print("err:", err)
 			return err
 		}
 
 		configFilePath := filepath.Join(kConfigDir, fmt.Sprintf("%s.yaml", app.Name))
-		err = ioutil.WriteFile(configFilePath, []byte(cData), 0666)
-		if err != nil {
-			return err
+		if dryRun {
+			plan.AddManifest("kubeapp-"+app.Name, configFilePath, cData)
+		} else {
+			err = ioutil.WriteFile(configFilePath, []byte(cData), 0600)
+			if err != nil {
+				return err
+			}
 		}
 
-		err = c.kubectlRunAndWait(configFilePath, app.Name)
+		err = c.kubectlRunAndWait(ctx, []byte(cData), app.Name)
+		if c.Journal != nil && !dryRun {
+			status := StepDone
+			if err != nil && ctx.Err() != nil {
+				status = StepInterrupted
+			} else if err != nil {
+				status = StepFailed
+			}
+			jErr := c.Journal.Record(step, status, err)
+			if jErr != nil {
+				fmt.Println("unable to record step in journal:", jErr)
+			}
+		}
 		if err != nil {
-			continue
+			failed = append(failed, app.Name)
 		}
 	}
 
+	if len(failed) > 0 {
+		return fmt.Errorf("installing kubeapp(s) %s failed", strings.Join(failed, ", "))
+	}
 	return nil
 }
 
@@ -100,11 +144,16 @@ type externalDNSCfg struct {
 	Email                    string
 }
 
+// With WorkloadIdentity set, the templates get the names of the Kubernetes
+// service accounts to run as instead of the names of the secrets holding
+// the service account keys.
 type clusterIssuerCfg struct {
 	Email                string
 	ProjectName          string
 	ServiceAccountSecret string
 	SecretFileKey        string
+	WorkloadIdentity     bool
+	ServiceAccountName   string
 }
 
 type wildCardCertCfg struct {
@@ -112,9 +161,12 @@ type wildCardCertCfg struct {
 }
 
 type generatorCfg struct {
-	ClusterIssuer string
-	DNSName       string
-	Envs          []env
+	ClusterIssuer                string
+	DNSName                      string
+	Envs                         []env
+	WorkloadIdentity             bool
+	CloudBuildServiceAccountName string
+	StorageServiceAccountName    string
 }
 
 type env struct {
@@ -122,7 +174,7 @@ type env struct {
 	Value string
 }
 
-func (c *Cluster) valueFromTemplate(app App, templateData string) (string, error) {
+func (c *Cluster) valueFromTemplate(ctx context.Context, app App, templateData string) (string, error) {
 	switch app.Name {
 	case "externalDNS":
 		ec := externalDNSCfg{
@@ -147,16 +199,30 @@ func (c *Cluster) valueFromTemplate(app App, templateData string) (string, error
 		return cnf, nil
 	case "cluster-issuer":
 		ci := clusterIssuerCfg{
-			Email:                c.Account,
-			ProjectName:          c.GcloudProjectName,
-			ServiceAccountSecret: c.GetServiceAccountOpts().DNSName,
-			SecretFileKey:        c.GetServiceAccountOpts().DNSName,
-		}
-		_ = c.createSecret(
-			c.GetServiceAccountOpts().DNSName,
-			"cert-manager",
-			filepath.Join(c.ConfPath, c.GetServiceAccountOpts().DNSName+".json"),
-		)
+			Email:       c.Account,
+			ProjectName: c.GcloudProjectName,
+		}
+		if c.WorkloadIdentity {
+			ksa := c.DNSKubeServiceAccount()
+			ci.WorkloadIdentity = true
+			ci.ServiceAccountName = ksa.Name
+			err := c.createKubeServiceAccount(ctx, ksa)
+			if err != nil {
+				return "", err
+			}
+		} else {
+			ci.ServiceAccountSecret = c.GetServiceAccountOpts().DNSName
+			ci.SecretFileKey = c.GetServiceAccountOpts().DNSName
+			err := c.createSecret(
+				ctx,
+				c.GetServiceAccountOpts().DNSName,
+				certManagerNamespace,
+				c.KeyPath(c.GetServiceAccountOpts().DNSName),
+			)
+			if err != nil {
+				return "", err
+			}
+		}
 		cnf, err := generateKubeAppConfigFromTemplate(ci, templateData)
 		if err != nil {
 			return "", err
@@ -193,7 +259,7 @@ func (c *Cluster) valueFromTemplate(app App, templateData string) (string, error
 				},
 				{
 					Name:  "COMPUTE_ZONE",
-					Value: c.Zone,
+					Value: c.Location(),
 				},
 				{
 					Name:  "DNS_NAME",
@@ -205,27 +271,41 @@ func (c *Cluster) valueFromTemplate(app App, templateData string) (string, error
 				},
 			},
 		}
-		err := c.createNamespace("generator")
+		err := c.createNamespace(ctx, generatorNamespace)
 		if err != nil {
-			fmt.Println("err:", err)
+			return "", err
 		}
 
-		err = c.createSecret(
-			"cloudbuild-secret",
-			"generator",
-			filepath.Join(c.ConfPath, c.GetServiceAccountOpts().CloudBuildName+".json"),
-		)
-		if err != nil {
-			fmt.Println("err:", err)
-		}
+		if c.WorkloadIdentity {
+			gn.WorkloadIdentity = true
+			for _, ksa := range []KubeServiceAccount{c.CloudBuildKubeServiceAccount(), c.StorageKubeServiceAccount()} {
+				err = c.createKubeServiceAccount(ctx, ksa)
+				if err != nil {
+					return "", err
+				}
+			}
+			gn.CloudBuildServiceAccountName = c.CloudBuildKubeServiceAccount().Name
+			gn.StorageServiceAccountName = c.StorageKubeServiceAccount().Name
+		} else {
+			err = c.createSecret(
+				ctx,
+				"cloudbuild-secret",
+				generatorNamespace,
+				c.KeyPath(c.GetServiceAccountOpts().CloudBuildName),
+			)
+			if err != nil {
+				return "", err
+			}
 
-		err = c.createSecret(
-			"cloudstorage-secret",
-			"generator",
-			filepath.Join(c.ConfPath, c.GetServiceAccountOpts().StorageName+".json"),
-		)
-		if err != nil {
-			fmt.Println("err:", err)
+			err = c.createSecret(
+				ctx,
+				"cloudstorage-secret",
+				generatorNamespace,
+				c.KeyPath(c.GetServiceAccountOpts().StorageName),
+			)
+			if err != nil {
+				return "", err
+			}
 		}
 
 		cnf, err := generateKubeAppConfigFromTemplate(gn, templateData)
@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// KubeconfigFile is the kubeconfig kept in the config dir of every cluster.
+const KubeconfigFile = "kubeconfig"
+
+// KubeconfigPath returns the kubeconfig of c. kubectl is always pointed at
+// it, so kmanager never depends on, nor changes, the current context of
+// the user's own kubeconfig.
+func (c *Cluster) KubeconfigPath() string {
+	return filepath.Join(c.ConfPath, KubeconfigFile)
+}
+
+func credentialsArgs(c *Cluster) []string {
+	args := []string{
+		"container", "clusters", "get-credentials",
+		c.Name,
+		"--project", c.GcloudProjectName,
+	}
+	return append(args, c.LocationArgs()...)
+}
+
+// restrictKubeconfig keeps the kubeconfig, which may cache access tokens,
+// readable by the user only.
+func (c *Cluster) restrictKubeconfig() error {
+	err := os.Chmod(c.KubeconfigPath(), 0600)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func restrictKubeconfigAfter(c *Cluster) func(context.Context, *Command) error {
+	return func(ctx context.Context, cmd *Command) error {
+		if !cmd.Succeed {
+			return nil
+		}
+		return c.restrictKubeconfig()
+	}
+}
+
+// FetchKubeconfig writes the credentials of c into its kubeconfig. It runs
+// quietly, so the output of its callers can be consumed by scripts.
+func (c *Cluster) FetchKubeconfig(ctx context.Context) error {
+	e := c.Exec
+	if e == nil {
+		e = DefaultExecutor
+	}
+
+	p := Process{
+		Name:    "get-kubernetes-credentials",
+		RootCmd: "gcloud",
+		Args:    credentialsArgs(c),
+		Env:     c.env(),
+	}
+	res, err := e.Exec(ctx, p)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return newCommandError(p, res, err)
+	}
+	return c.restrictKubeconfig()
+}
+
+// EnsureKubeconfig fetches the kubeconfig of c unless it exists, e.g. for
+// clusters created before kmanager kept one.
+func (c *Cluster) EnsureKubeconfig(ctx context.Context) error {
+	_, err := os.Stat(c.KubeconfigPath())
+	if err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return c.FetchKubeconfig(ctx)
+}
@@ -2,8 +2,15 @@ package cluster
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/urvil38/kmanager/config"
 )
 
 func (c *Cluster) InitKubeCmdSet() (*CmdSet, error) {
@@ -11,39 +18,47 @@ func (c *Cluster) InitKubeCmdSet() (*CmdSet, error) {
 
 	cmds := []Command{
 		{
-			Name:    "create-kubernetes-cluster",
-			RootCmd: "gcloud",
+			Name:      "create-kubernetes-cluster",
+			RootCmd:   "gcloud",
+			DependsOn: []string{"list-gcloud-accounts"},
+			Timeout:   30 * time.Minute,
 			GenerateArgs: func(c *Cluster) []string {
-				return []string{
+				args := []string{
 					"container", "clusters", "create", c.Name,
 					"--project", c.GcloudProjectName,
-					"--zone", c.Zone,
+				}
+				args = append(args, c.LocationArgs()...)
+				if len(c.NodeLocations) > 0 {
+					args = append(args, "--node-locations", strings.Join(c.NodeLocations, ","))
+				}
+				args = append(args,
 					"--no-enable-basic-auth",
-					"--machine-type", "n1-standard-1",
-					"--image-type", "COS",
-					"--disk-type", "pd-standard",
-					"--disk-size=10",
-					"--scopes",
-					"https://www.googleapis.com/auth/devstorage.read_only,https://www.googleapis.com/auth/logging.write,https://www.googleapis.com/auth/monitoring,https://www.googleapis.com/auth/servicecontrol,https://www.googleapis.com/auth/service.management.readonly,https://www.googleapis.com/auth/trace.append,https://www.googleapis.com/auth/ndev.clouddns.readwrite",
-					"--preemptible",
-					"--num-nodes=2",
+					"--machine-type", c.Nodes.MachineType,
+					"--image-type", c.Nodes.ImageType,
+					"--disk-type", c.Nodes.DiskType,
+					fmt.Sprintf("--disk-size=%d", c.Nodes.DiskSizeGB),
+					"--scopes", strings.Join(c.Nodes.Scopes, ","),
 					"--network", fmt.Sprintf("projects/%s/global/networks/default", c.GcloudProjectName),
 					"--subnetwork", fmt.Sprintf("projects/%s/regions/%s/subnetworks/default", c.GcloudProjectName, c.Region),
 					"--addons", "HttpLoadBalancing",
+					fmt.Sprintf("--num-nodes=%d", c.Nodes.NumNodes),
+				)
+				if c.Nodes.Preemptible {
+					args = append(args, "--preemptible")
+				}
+				if c.WorkloadIdentity {
+					args = append(args, "--workload-pool", c.WorkloadPool())
 				}
+				args = append(args, "--labels", joinLabels(c.ResourceLabels()))
+				return args
 			},
 		},
 		{
-			Name:    "get-kubernetes-credentials",
-			RootCmd: "gcloud",
-			GenerateArgs: func(c *Cluster) []string {
-				return []string{
-					"container", "clusters", "get-credentials",
-					c.Name,
-					"--zone", c.Zone,
-					"--project", c.GcloudProjectName,
-				}
-			},
+			Name:         "get-kubernetes-credentials",
+			RootCmd:      "gcloud",
+			DependsOn:    []string{"create-kubernetes-cluster"},
+			GenerateArgs: credentialsArgs,
+			AfterFn:      restrictKubeconfigAfter(c),
 		},
 		// Note: When running on GKE (Google Kubernetes Engine),
 		// you may encounter a ‘permission denied’ error when creating some of these resources.
@@ -56,8 +71,9 @@ func (c *Cluster) InitKubeCmdSet() (*CmdSet, error) {
 		//    --user=$(gcloud config get-value core/account)
 		//
 		{
-			Name:    "gke-cluster-admin-role",
-			RootCmd: "kubectl",
+			Name:      "gke-cluster-admin-role",
+			RootCmd:   "kubectl",
+			DependsOn: []string{"get-kubernetes-credentials"},
 			GenerateArgs: func(c *Cluster) []string {
 				return []string{
 					"create", "clusterrolebinding", "cluster-admin-binding", "--clusterrole=cluster-admin",
@@ -74,31 +90,48 @@ func (c *Cluster) InitKubeCmdSet() (*CmdSet, error) {
 	return kubernetesCmds, nil
 }
 
-func (c *Cluster) createSecret(name, namespace, filepath string) error {
+// createSecret creates the generic secret name holding the key at keyPath,
+// which is decrypted first when it is kept encrypted. The secret is piped
+// to kubectl, so the key never lands on disk in plaintext.
+func (c *Cluster) createSecret(ctx context.Context, name, namespace, keyPath string) error {
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata":   map[string]string{"name": name, "namespace": namespace},
+	}
+	if keyPath != "" {
+		key, err := config.ReadSealed(keyPath)
+		if _, dryRun := c.Exec.(*Plan); dryRun && errors.Is(err, os.ErrNotExist) {
+			// the key is generated by the run being planned
+		} else if err != nil {
+			return err
+		}
+		secret["data"] = map[string]string{name: base64.StdEncoding.EncodeToString(key)}
+	}
+
+	manifest, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
 	createCmd := Command{
 		Name:    name,
 		RootCmd: "kubectl",
-		GenerateArgs: func(c *Cluster) []string {
-			if filepath != "" {
-				return []string{
-					"create", "secret", "generic", name, fmt.Sprintf("--namespace=%s", namespace), fmt.Sprintf("--from-file=%s=%s", name, filepath),
-				}
-			} else {
-				return []string{
-					"create", "secret", "generic", name, fmt.Sprintf("--namespace=%s", namespace),
-				}
-			}
-		},
+		Args:    []string{"create", "-f", "-"},
+		Stdin:   manifest,
 	}
 
-	createCmd.Execute(context.Background(), c)
-	if !createCmd.Succeed {
+	createCmd.Execute(ctx, c)
+	if !createCmd.Succeed && !errors.Is(createCmd.Stderr, ErrAlreadyExists) {
 		return createCmd.Stderr
 	}
 	return nil
 }
 
-func (c *Cluster) kubectlRunAndWait(filePath string, appName string) error {
+// kubectlRunAndWait creates the objects of manifest, piped to kubectl, and
+// waits for them to come up.
+func (c *Cluster) kubectlRunAndWait(ctx context.Context, manifest []byte, appName string) error {
 	waitCmd := Command{
 		Name:    "wait-for-kubernetes-resources",
 		RootCmd: "kubectl",
@@ -115,8 +148,9 @@ func (c *Cluster) kubectlRunAndWait(filePath string, appName string) error {
 	applyCmd := Command{
 		Name:    "create-kubernetes-resources",
 		RootCmd: "kubectl",
-		Args:    []string{"create", "-f", filePath},
-		AfterFn: func(cmd *Command) error {
+		Args:    []string{"create", "-f", "-"},
+		Stdin:   manifest,
+		AfterFn: func(ctx context.Context, cmd *Command) error {
 			if !cmd.Succeed {
 				return cmd.Stderr
 			}
@@ -135,42 +169,50 @@ func (c *Cluster) kubectlRunAndWait(filePath string, appName string) error {
 			outer:
 				for {
 					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return ctx.Err()
 					case <-timer.C:
 						timer.Stop()
 						fmt.Println("timeout: kubectl get secret wildcard-cert-secret")
-						_ = c.CreateFakeSecret()
+						_ = c.CreateFakeSecret(ctx)
 						break outer
 					default:
-						checkCmd.Execute(context.Background(), c)
+						checkCmd.Execute(ctx, c)
 						if checkCmd.Succeed {
 							break outer
 						}
-						time.Sleep(5 * time.Second)
+						_ = sleep(ctx, 5*time.Second)
 					}
 				}
 			} else {
-				waitCmd.Execute(context.Background(), c)
+				waitCmd.Execute(ctx, c)
 			}
 			return nil
 		},
 	}
 
-	applyCmd.Execute(context.Background(), c)
+	applyCmd.Execute(ctx, c)
 	if !applyCmd.Succeed {
-		fmt.Println(applyCmd.Stderr)
+		if errors.Is(applyCmd.Stderr, ErrAlreadyExists) {
+			fmt.Printf("%s: already exists, treating as done\n", appName)
+			return nil
+		}
+		fmt.Println(Explain(applyCmd.Stderr))
+		return applyCmd.Stderr
 	}
 	return nil
 }
 
-func (c *Cluster) createNamespace(name string) error {
+func (c *Cluster) createNamespace(ctx context.Context, name string) error {
 	nsCmd := Command{
 		Name:    "create-kubernetes-namespace",
 		RootCmd: "kubectl",
 		Args:    []string{"create", "ns", name},
 	}
 
-	nsCmd.Execute(context.Background(), c)
-	if !nsCmd.Succeed {
+	nsCmd.Execute(ctx, c)
+	if !nsCmd.Succeed && !errors.Is(nsCmd.Stderr, ErrAlreadyExists) {
 		return nsCmd.Stderr
 	}
 	return nil
@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// ClusterLabel holds the name of the cluster a resource belongs to.
+	ClusterLabel = "kmanager-cluster"
+	// ManagedByLabel marks every resource created by kmanager.
+	ManagedByLabel = "managed-by"
+	managedByValue = "kmanager"
+)
+
+var (
+	labelKeyRegex   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueRegex = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// ValidateLabels reports the first label GCP would reject. The labels set
+// by kmanager itself can not be overridden.
+func ValidateLabels(labels map[string]string) error {
+	for _, k := range sortedKeys(labels) {
+		if k == ClusterLabel || k == ManagedByLabel {
+			return fmt.Errorf("label \"%s\" is set by kmanager and can not be overridden", k)
+		}
+		if !labelKeyRegex.MatchString(k) {
+			return fmt.Errorf("invalid label key \"%s\", it has to start with a lowercase letter and can contain [ a-z 0-9 `_` or `-` ]", k)
+		}
+		if !labelValueRegex.MatchString(labels[k]) {
+			return fmt.Errorf("invalid value \"%s\" of label \"%s\", it can contain [ a-z 0-9 `_` or `-` ]", labels[k], k)
+		}
+	}
+	return nil
+}
+
+// ResourceLabels returns the labels stamped on every resource of c: the
+// user defined ones plus those identifying the cluster.
+func (c *Cluster) ResourceLabels() map[string]string {
+	labels := make(map[string]string, len(c.Labels)+2)
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+	labels[ClusterLabel] = strings.ToLower(c.Name)
+	labels[ManagedByLabel] = managedByValue
+	return labels
+}
+
+// gsutilLabelArgs returns the gsutil arguments adding labels to bucket.
+func gsutilLabelArgs(labels map[string]string, bucket string) []string {
+	args := []string{"label", "ch"}
+	for _, k := range sortedKeys(labels) {
+		args = append(args, "-l", k+":"+labels[k])
+	}
+	return append(args, "gs://"+bucket)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
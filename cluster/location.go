@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// LocationZonal clusters have a single control plane in Zone. It is
+	// assumed for configs without a location mode.
+	LocationZonal = "zonal"
+	// LocationRegional clusters replicate the control plane across the
+	// zones of Region.
+	LocationRegional = "regional"
+)
+
+// LocationModes are the valid values of Cluster.LocationMode.
+var LocationModes = []string{LocationZonal, LocationRegional}
+
+// Regional reports whether c has a regional control plane.
+func (c *Cluster) Regional() bool {
+	return c.LocationMode == LocationRegional
+}
+
+// Location returns the region of a regional cluster or the zone of a zonal
+// one.
+func (c *Cluster) Location() string {
+	if c.Regional() {
+		return c.Region
+	}
+	return c.Zone
+}
+
+// LocationArgs returns the gcloud flag addressing c, every gcloud container
+// command has to use it so regional clusters are found.
+func (c *Cluster) LocationArgs() []string {
+	if c.Regional() {
+		return []string{"--region", c.Region}
+	}
+	return []string{"--zone", c.Zone}
+}
+
+// ValidateLocation reports whether the location mode and the node
+// locations fit Region and Zone.
+func (c *Cluster) ValidateLocation() error {
+	switch c.LocationMode {
+	case "", LocationZonal:
+		if c.Zone == "" {
+			return fmt.Errorf("a %s cluster needs a zone", LocationZonal)
+		}
+	case LocationRegional:
+		if c.Region == "" {
+			return fmt.Errorf("a %s cluster needs a region", LocationRegional)
+		}
+	default:
+		return fmt.Errorf("invalid location mode %q, expected one of %s", c.LocationMode, strings.Join(LocationModes, ", "))
+	}
+
+	hasZone := false
+	for _, l := range c.NodeLocations {
+		if c.Region != "" && !strings.HasPrefix(l, c.Region+"-") {
+			return fmt.Errorf("node location %q is not a zone of region %q", l, c.Region)
+		}
+		if l == c.Zone {
+			hasZone = true
+		}
+	}
+	if !c.Regional() && len(c.NodeLocations) > 0 && !hasZone {
+		return fmt.Errorf("node locations of a %s cluster have to include its zone %q", LocationZonal, c.Zone)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	nodePoolNameRegex = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,38}[a-z0-9])?$`)
+	taintRegex        = regexp.MustCompile(`^[^=:]+=[^=:]*:(NoSchedule|PreferNoSchedule|NoExecute)$`)
+)
+
+// NodePool is an additional GKE node pool managed through
+// 'kmanager nodepool'. The default node pool is described by NodeConfig.
+type NodePool struct {
+	Name        string            `json:"name"`
+	MachineType string            `json:"machine_type"`
+	NumNodes    int               `json:"num_nodes"`
+	DiskSizeGB  int               `json:"disk_size_gb,omitempty"`
+	Spot        bool              `json:"spot,omitempty"`
+	Preemptible bool              `json:"preemptible,omitempty"`
+	Autoscaling *Autoscaling      `json:"autoscaling,omitempty"`
+	Taints      []string          `json:"taints,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Autoscaling holds the bounds of the number of nodes of a node pool per
+// zone.
+type Autoscaling struct {
+	MinNodes int `json:"min_nodes"`
+	MaxNodes int `json:"max_nodes"`
+}
+
+// Validate reports the first field of p which gcloud would reject.
+func (p NodePool) Validate() error {
+	if !nodePoolNameRegex.MatchString(p.Name) {
+		return fmt.Errorf("invalid node pool name \"%s\", it can contain [ a-z 0-9 or `-` ] and must start with a letter", p.Name)
+	}
+	if p.MachineType == "" {
+		return errors.New("machine type is required")
+	}
+	if p.NumNodes < 0 {
+		return fmt.Errorf("number of nodes can not be negative, got %d", p.NumNodes)
+	}
+	if p.DiskSizeGB != 0 && p.DiskSizeGB < 10 {
+		return fmt.Errorf("disk size must be at least 10GB, got %d", p.DiskSizeGB)
+	}
+	if p.Spot && p.Preemptible {
+		return errors.New("a node pool can not be both spot and preemptible")
+	}
+	if p.Autoscaling != nil {
+		err := p.Autoscaling.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range p.Taints {
+		if !taintRegex.MatchString(t) {
+			return fmt.Errorf("invalid taint \"%s\", expected key=value:effect with effect one of NoSchedule, PreferNoSchedule or NoExecute", t)
+		}
+	}
+	return nil
+}
+
+// Validate reports whether the bounds can be used for autoscaling.
+func (a Autoscaling) Validate() error {
+	if a.MinNodes < 0 || a.MaxNodes < 1 || a.MinNodes > a.MaxNodes {
+		return fmt.Errorf("invalid autoscaling bounds, expected 0 <= min (%d) <= max (%d) and max >= 1", a.MinNodes, a.MaxNodes)
+	}
+	return nil
+}
+
+// NodePool returns the recorded node pool of the given name.
+func (c *Cluster) NodePool(name string) (NodePool, bool) {
+	for _, p := range c.NodePools {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return NodePool{}, false
+}
+
+// SetNodePool records p, replacing a node pool of the same name.
+func (c *Cluster) SetNodePool(p NodePool) {
+	for i := range c.NodePools {
+		if c.NodePools[i].Name == p.Name {
+			c.NodePools[i] = p
+			return
+		}
+	}
+	c.NodePools = append(c.NodePools, p)
+}
+
+// RemoveNodePool forgets the node pool of the given name.
+func (c *Cluster) RemoveNodePool(name string) {
+	pools := c.NodePools[:0]
+	for _, p := range c.NodePools {
+		if p.Name != name {
+			pools = append(pools, p)
+		}
+	}
+	c.NodePools = pools
+}
+
+// CreateNodePoolArgs returns the gcloud arguments creating p in c.
+func (c *Cluster) CreateNodePoolArgs(p NodePool) []string {
+	args := []string{
+		"container", "node-pools", "create", p.Name,
+		"--cluster", c.Name,
+		"--project", c.GcloudProjectName,
+		"--machine-type", p.MachineType,
+		fmt.Sprintf("--num-nodes=%d", p.NumNodes),
+	}
+	args = append(args, c.LocationArgs()...)
+	if p.DiskSizeGB != 0 {
+		args = append(args, fmt.Sprintf("--disk-size=%d", p.DiskSizeGB))
+	}
+	if p.Spot {
+		args = append(args, "--spot")
+	}
+	if p.Preemptible {
+		args = append(args, "--preemptible")
+	}
+	if p.Autoscaling != nil {
+		args = append(args, autoscalingArgs(*p.Autoscaling)...)
+	}
+	if len(p.Taints) > 0 {
+		args = append(args, "--node-taints", strings.Join(p.Taints, ","))
+	}
+	if len(p.Labels) > 0 {
+		args = append(args, "--node-labels", joinLabels(p.Labels))
+	}
+	if c.WorkloadIdentity {
+		args = append(args, "--workload-metadata", "GKE_METADATA")
+	}
+	return append(args, "--labels", joinLabels(c.ResourceLabels()))
+}
+
+// ResizeNodePoolArgs returns the gcloud arguments setting the number of
+// nodes of the node pool of the given name.
+func (c *Cluster) ResizeNodePoolArgs(name string, numNodes int) []string {
+	args := []string{
+		"container", "clusters", "resize", c.Name,
+		"--node-pool", name,
+		fmt.Sprintf("--num-nodes=%d", numNodes),
+		"--project", c.GcloudProjectName,
+		"--quiet",
+	}
+	return append(args, c.LocationArgs()...)
+}
+
+// AutoscaleNodePoolArgs returns the gcloud arguments setting the
+// autoscaling bounds of the node pool of the given name.
+func (c *Cluster) AutoscaleNodePoolArgs(name string, a Autoscaling) []string {
+	args := []string{
+		"container", "clusters", "update", c.Name,
+		"--node-pool", name,
+		"--project", c.GcloudProjectName,
+	}
+	args = append(args, c.LocationArgs()...)
+	return append(args, autoscalingArgs(a)...)
+}
+
+// ListNodePoolsArgs returns the gcloud arguments listing the node pools of
+// c.
+func (c *Cluster) ListNodePoolsArgs() []string {
+	args := []string{
+		"container", "node-pools", "list",
+		"--cluster", c.Name,
+		"--project", c.GcloudProjectName,
+	}
+	return append(args, c.LocationArgs()...)
+}
+
+// DeleteNodePoolArgs returns the gcloud arguments deleting the node pool of
+// the given name.
+func (c *Cluster) DeleteNodePoolArgs(name string) []string {
+	args := []string{
+		"container", "node-pools", "delete", name,
+		"--cluster", c.Name,
+		"--project", c.GcloudProjectName,
+		"--quiet",
+	}
+	return append(args, c.LocationArgs()...)
+}
+
+func autoscalingArgs(a Autoscaling) []string {
+	return []string{
+		"--enable-autoscaling",
+		fmt.Sprintf("--min-nodes=%d", a.MinNodes),
+		fmt.Sprintf("--max-nodes=%d", a.MaxNodes),
+	}
+}
+
+// joinLabels formats labels as gcloud expects them, sorted so the command
+// line is stable.
+func joinLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
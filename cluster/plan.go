@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Plan is an Executor which records every mutating process instead of
+// running it. Read-only processes are passed through to the wrapped
+// executor so prompts relying on them keep working during a dry run.
+type Plan struct {
+	Cluster string     `json:"cluster"`
+	Steps   []PlanStep `json:"steps"`
+
+	mu   sync.Mutex
+	next Executor
+}
+
+// PlanStep is a single command or rendered manifest of a Plan.
+type PlanStep struct {
+	Name     string   `json:"name"`
+	RootCmd  string   `json:"root_cmd,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	Env      []string `json:"env,omitempty"`
+	Dir      string   `json:"dir,omitempty"`
+	Stdin    int      `json:"stdin_bytes,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Manifest string   `json:"manifest,omitempty"`
+}
+
+// NewPlan returns a Plan which forwards read-only processes to next.
+func NewPlan(next Executor) *Plan {
+	if next == nil {
+		next = DefaultExecutor
+	}
+	return &Plan{next: next}
+}
+
+func (p *Plan) Exec(ctx context.Context, proc Process) (ProcessResult, error) {
+	if proc.ReadOnly {
+		return p.next.Exec(ctx, proc)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Steps = append(p.Steps, PlanStep{
+		Name:    proc.Name,
+		RootCmd: proc.RootCmd,
+		Args:    proc.Args,
+		Env:     RedactEnv(proc.Env),
+		Dir:     proc.Dir,
+		Stdin:   len(proc.Stdin),
+	})
+	return ProcessResult{}, nil
+}
+
+// AddManifest records a manifest which would have been written to path.
+func (p *Plan) AddManifest(name, path, manifest string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Steps = append(p.Steps, PlanStep{
+		Name:     name,
+		Path:     path,
+		Manifest: manifest,
+	})
+}
+
+// WriteText writes the plan in a human readable form.
+func (p *Plan) WriteText(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := fmt.Fprintf(w, "Plan for cluster %q (dry run, nothing was executed):\n\n", p.Cluster)
+	if err != nil {
+		return err
+	}
+	for i, s := range p.Steps {
+		if s.RootCmd != "" {
+			line := ProcessLine(Process{RootCmd: s.RootCmd, Args: s.Args, Env: s.Env, Dir: s.Dir})
+			if s.Stdin > 0 {
+				line += fmt.Sprintf(" (%d bytes on stdin)", s.Stdin)
+			}
+			_, err = fmt.Fprintf(w, "%3d. %s\n     $ %s\n", i+1, s.Name, line)
+		} else {
+			_, err = fmt.Fprintf(w, "%3d. %s\n     write %s\n%s\n", i+1, s.Name, s.Path, indent(s.Manifest, "       | "))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the plan as indented JSON so two plans can be diffed.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(p)
+}
+
+// CommandLine renders rootCmd and args the way they would be typed in a
+// shell.
+func CommandLine(rootCmd string, args []string) string {
+	parts := []string{rootCmd}
+	for _, a := range args {
+		parts = append(parts, shellQuote(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(a string) string {
+	if a == "" || strings.ContainsAny(a, " \t\n'\"$*?|&;<>()") {
+		return strconv.Quote(a)
+	}
+	return a
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isPlanned reports whether c is only being recorded by a Plan, in which
+// case its AfterFn must not run on the empty output.
+func isPlanned(e Executor, c *Command) bool {
+	_, ok := e.(*Plan)
+	return ok && !c.ReadOnly
+}
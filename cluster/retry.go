@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy tells Command.Execute how to retry a failed command.
+// Backoff starts at InitialBackoff and is multiplied by Multiplier after
+// every attempt, capped at MaxBackoff. Jitter randomizes every wait by up to
+// that fraction of it.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+	// Retryable reports whether a failure is worth retrying. When nil,
+	// IsTransient is used.
+	Retryable func(error) bool
+}
+
+// IAMRetryPolicy covers IAM changes on freshly created service accounts,
+// which fail until the account has propagated.
+var IAMRetryPolicy = &RetryPolicy{
+	MaxAttempts:    6,
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	Retryable:      IsPropagating,
+}
+
+// BucketRetryPolicy covers changes to freshly created buckets. Buckets are
+// usable as soon as they are created, so only transient failures of the
+// storage api are retried.
+var BucketRetryPolicy = &RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Second,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	Retryable:      IsTransient,
+}
+
+// IsTransient reports whether err may go away by retrying, that is whether
+// it was recognized as a rate limit or a server side failure. Anything else,
+// like a missing executable or a bad argument, fails the same way again.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return errors.Is(err, ErrTransient)
+}
+
+// IsPropagating reports whether err is transient or may be caused by a
+// service account which was just created but has not propagated yet, in
+// which case it is reported as not found.
+func IsPropagating(err error) bool {
+	return IsTransient(err) || errors.Is(err, ErrNotFound)
+}
+
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// backoff returns the wait before the attempt following attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	wait := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		wait *= multiplier
+		if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+			wait = float64(p.MaxBackoff)
+			break
+		}
+	}
+
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(wait)
+}
+
+// sleep waits for d or until ctx is done, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	commandError := func(stderr string) error {
+		p := Process{Name: "label-storage-bucket-soucecode", RootCmd: "gsutil"}
+		return newCommandError(p, ProcessResult{ExitCode: 1, Stderr: stderr}, errors.New("exit status 1"))
+	}
+
+	tests := []struct {
+		name        string
+		err         error
+		transient   bool
+		propagating bool
+	}{
+		{"nil", nil, false, false},
+		{"canceled", context.Canceled, false, false},
+		{"timed out", fmt.Errorf("step: %w", context.DeadlineExceeded), false, false},
+		{"unrecognized", commandError("ERROR: something odd happened"), false, false},
+		{"missing executable", errors.New(`exec: "gsutil": executable file not found in $PATH`), false, false},
+		{"bad argument", commandError("ERROR: (gcloud.iam.service-accounts.create) INVALID_ARGUMENT: Invalid account id"), false, false},
+		{"rate limit", commandError("ERROR: (gcloud.projects.add-iam-policy-binding) RATE_LIMIT_EXCEEDED: too many requests"), true, true},
+		{"per minute quota", commandError("Quota exceeded for quota metric 'Write requests' and limit 'Write requests per minute'"), true, true},
+		{"gke 503", commandError("ERROR: (gcloud.container.clusters.create) ResponseError: code=503, message=try again"), true, true},
+		{"gsutil 503", commandError("ServiceException: 503 Service Unavailable."), true, true},
+		{"unavailable", commandError("ERROR: (gcloud.iam.service-accounts.keys.create) UNAVAILABLE: The service is currently unavailable."), true, true},
+		{"account not propagated", commandError("ERROR: Policy modification failed. INVALID_ARGUMENT: Service account demo-storage@proj.iam.gserviceaccount.com does not exist."), false, true},
+		{"permission denied", commandError("ERROR: PERMISSION_DENIED: denied"), false, false},
+		{"quota", commandError("ERROR: QUOTA_EXCEEDED: Quota 'CPUS' exceeded"), false, false},
+		{"already exists", commandError("ERROR: ALREADY_EXISTS: demo"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.transient {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.transient)
+			}
+			if got := IsPropagating(tt.err); got != tt.propagating {
+				t.Errorf("IsPropagating(%v) = %v, want %v", tt.err, got, tt.propagating)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{
+		MaxAttempts:    6,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+	}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{10, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			if got := p.backoff(tt.attempt); got != tt.want {
+				t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+
+			jittered := *p
+			jittered.Jitter = 0.2
+			for i := 0; i < 100; i++ {
+				got := jittered.backoff(tt.attempt)
+				if min, max := tt.want*8/10, tt.want*12/10; got < min || got > max {
+					t.Fatalf("backoff(%d) with jitter = %s, want within [%s, %s]", tt.attempt, got, min, max)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteRetry(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+
+	tests := []struct {
+		name      string
+		responses []FakeResponse
+		retry     *RetryPolicy
+		succeed   bool
+		attempts  int
+	}{
+		{
+			name:     "succeeds at once",
+			retry:    policy,
+			succeed:  true,
+			attempts: 1,
+		},
+		{
+			name: "transient failure is retried",
+			responses: []FakeResponse{
+				{RootCmd: "gsutil", ExitCode: 1, Stderr: "ServiceException: 503 Service Unavailable."},
+				{RootCmd: "gsutil", ExitCode: 1, Stderr: "ServiceException: 503 Service Unavailable."},
+			},
+			retry:    policy,
+			succeed:  true,
+			attempts: 3,
+		},
+		{
+			name: "gives up after the last attempt",
+			responses: []FakeResponse{
+				{RootCmd: "gsutil", ExitCode: 1, Stderr: "ServiceException: 503 Service Unavailable."},
+				{RootCmd: "gsutil", ExitCode: 1, Stderr: "ServiceException: 503 Service Unavailable."},
+				{RootCmd: "gsutil", ExitCode: 1, Stderr: "ServiceException: 503 Service Unavailable."},
+			},
+			retry:    policy,
+			attempts: 3,
+		},
+		{
+			name:      "unrecognized failure is not retried",
+			responses: []FakeResponse{{RootCmd: "gsutil", ExitCode: 1, Stderr: "CommandException: Incorrect option(s) specified."}},
+			retry:     policy,
+			attempts:  1,
+		},
+		{
+			name:      "no policy",
+			responses: []FakeResponse{{RootCmd: "gsutil", ExitCode: 1, Stderr: "ServiceException: 503 Service Unavailable."}},
+			attempts:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFakeExecutor(append(tt.responses, FakeResponse{RootCmd: "gsutil"})...)
+			cmd := Command{Name: "label-storage-bucket-soucecode", RootCmd: "gsutil", Args: []string{"label", "ch"}, Retry: tt.retry, Executor: f}
+
+			cmd.Execute(context.Background(), nil)
+			if cmd.Succeed != tt.succeed {
+				t.Errorf("Succeed = %v, want %v: %v", cmd.Succeed, tt.succeed, cmd.Stderr)
+			}
+			if len(f.Calls) != tt.attempts {
+				t.Errorf("run %d times, want %d", len(f.Calls), tt.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultConcurrency is used by CmdSet.Run when no concurrency is set.
+const DefaultConcurrency = 4
+
+type cyclicDependency struct {
+	names []string
+}
+
+func (c cyclicDependency) Error() string {
+	return fmt.Sprintf("cyclic dependency between commands %s", strings.Join(c.names, ", "))
+}
+
+type dependencyFailed struct {
+	name       string
+	dependency string
+}
+
+func (d dependencyFailed) Error() string {
+	return fmt.Sprintf("%s skipped: dependency %s did not succeed", d.name, d.dependency)
+}
+
+// RunError lists the commands of a CmdSet which failed, were skipped
+// because one of their dependencies failed, or were in flight when the run
+// was cancelled. Err holds the cancellation cause, if any.
+type RunError struct {
+	Failed      []string
+	Skipped     []string
+	Interrupted []string
+	Err         error
+}
+
+func (r *RunError) Error() string {
+	var msgs []string
+	if r.Err != nil {
+		msgs = append(msgs, fmt.Sprintf("%v while running: %s", r.Err, strings.Join(r.Interrupted, ", ")))
+	}
+	if len(r.Failed) > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d command(s) failed: %s", len(r.Failed), strings.Join(r.Failed, ", ")))
+	}
+	if len(r.Skipped) > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d skipped: %s", len(r.Skipped), strings.Join(r.Skipped, ", ")))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (r *RunError) Unwrap() error {
+	return r.Err
+}
+
+// Order returns the non-internal commands sorted so that every command
+// comes after its dependencies. Commands without an ordering constraint keep
+// the order in which they were added.
+func (cs *CmdSet) Order() ([]Command, error) {
+	indeg, dependents, err := cs.graph()
+	if err != nil {
+		return nil, err
+	}
+
+	var order []Command
+	var ready []string
+	for _, cmd := range cs.Cmds {
+		if !cmd.Internal && indeg[cmd.Name] == 0 {
+			ready = append(ready, cmd.Name)
+		}
+	}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, cs.CmdMap[name])
+		for _, d := range dependents[name] {
+			indeg[d]--
+			if indeg[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if len(order) != len(indeg) {
+		var cyclic []string
+		for name, n := range indeg {
+			if n > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, cyclicDependency{names: cyclic}
+	}
+
+	return order, nil
+}
+
+// graph returns the number of unmet dependencies of every non-internal
+// command and, for every command, the commands depending on it. Internal
+// commands are never run by Run, so depending on them is always satisfied.
+func (cs *CmdSet) graph() (map[string]int, map[string][]string, error) {
+	indeg := make(map[string]int)
+	dependents := make(map[string][]string)
+
+	for _, cmd := range cs.Cmds {
+		if cmd.Internal {
+			continue
+		}
+		indeg[cmd.Name] = 0
+		for _, dep := range cmd.DependsOn {
+			d, isthere := cs.CmdMap[dep]
+			if !isthere {
+				return nil, nil, fmt.Errorf("%s: %w", cmd.Name, commandNotFound{name: dep})
+			}
+			if d.Internal {
+				continue
+			}
+			indeg[cmd.Name]++
+			dependents[dep] = append(dependents[dep], cmd.Name)
+		}
+	}
+
+	return indeg, dependents, nil
+}
+
+// Run executes the non-internal commands of the set in dependency order,
+// running up to Concurrency independent commands at once. When a command
+// fails only the commands depending on it, directly or not, are skipped.
+// The executed commands are stored back into the set.
+func (cs *CmdSet) Run(ctx context.Context) error {
+	indeg, dependents, err := cs.graph()
+	if err != nil {
+		return err
+	}
+	if _, err := cs.Order(); err != nil {
+		return err
+	}
+
+	limit := cs.Concurrency
+	if limit <= 0 {
+		limit = DefaultConcurrency
+	}
+
+	var ready []string
+	for _, cmd := range cs.Cmds {
+		if !cmd.Internal && indeg[cmd.Name] == 0 {
+			ready = append(ready, cmd.Name)
+		}
+	}
+
+	type result struct {
+		cmd     Command
+		resumed bool
+	}
+
+	j := cs.journal()
+	done := make(chan result)
+	running := 0
+	runErr := &RunError{}
+	skipped := make(map[string]bool)
+
+	var skip func(name, dependency string)
+	skip = func(name, dependency string) {
+		for _, d := range dependents[name] {
+			if skipped[d] {
+				continue
+			}
+			skipped[d] = true
+			cmd := cs.CmdMap[d]
+			cmd.Stderr = dependencyFailed{name: d, dependency: dependency}
+			cs.store(cmd)
+			fmt.Println(cmd.Stderr)
+			runErr.Skipped = append(runErr.Skipped, d)
+			skip(d, dependency)
+		}
+	}
+
+	for running > 0 || (len(ready) > 0 && ctx.Err() == nil) {
+		for len(ready) > 0 && running < limit && ctx.Err() == nil {
+			cmd := cs.CmdMap[ready[0]]
+			ready = ready[1:]
+			if cmd.Timeout == 0 {
+				cmd.Timeout = cs.StepTimeout
+			}
+			running++
+			go func(cmd Command) {
+				if j != nil && j.Done(cmd.Name) {
+					cmd.Succeed = true
+					done <- result{cmd: cmd, resumed: true}
+					return
+				}
+				cmd.Execute(ctx, cs.C)
+				done <- result{cmd: cmd}
+			}(cmd)
+		}
+
+		r := <-done
+		cmd := r.cmd
+		running--
+		interrupted := !cmd.Succeed && ctx.Err() != nil
+
+		// a resumed run finds the resources of the earlier run in place,
+		// a fresh one must not take over resources it did not create
+		adopted := false
+		if cs.Adopt && !cmd.Succeed && errors.Is(cmd.Stderr, ErrAlreadyExists) {
+			fmt.Printf("%s: already exists, adopting it\n", cmd.Name)
+			cmd.Succeed = true
+			cmd.Stderr = nil
+			adopted = true
+		}
+		cs.store(cmd)
+
+		if r.resumed {
+			fmt.Printf("%s: already done, skipping\n", cmd.Name)
+		} else if j != nil {
+			err := cs.record(j, cmd, interrupted, adopted)
+			if err != nil {
+				fmt.Println("unable to record step in journal:", err)
+			}
+		}
+
+		if interrupted {
+			fmt.Printf("%s: interrupted, its outcome is unknown\n", cmd.Name)
+			runErr.Interrupted = append(runErr.Interrupted, cmd.Name)
+			continue
+		}
+
+		if !cmd.Succeed {
+			fmt.Printf("%s failed: %s\n", cmd.Name, Explain(cmd.Stderr))
+			runErr.Failed = append(runErr.Failed, cmd.Name)
+			skip(cmd.Name, cmd.Name)
+			continue
+		}
+
+		for _, d := range dependents[cmd.Name] {
+			indeg[d]--
+			if indeg[d] == 0 && !skipped[d] {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	runErr.Err = ctx.Err()
+	if len(runErr.Failed) > 0 || runErr.Err != nil {
+		return runErr
+	}
+	return nil
+}
+
+// store replaces the stored copies of cmd with the executed one.
+func (cs *CmdSet) store(cmd Command) {
+	cs.CmdMap[cmd.Name] = cmd
+	for i := range cs.Cmds {
+		if cs.Cmds[i].Name == cmd.Name {
+			cs.Cmds[i] = cmd
+		}
+	}
+}
+
+func (cs *CmdSet) journal() *Journal {
+	if cs.C == nil {
+		return nil
+	}
+	return cs.C.Journal
+}
+
+// record journals the outcome of cmd. Successful steps also persist the
+// cluster config, so a resumed run sees the values they gathered.
+func (cs *CmdSet) record(j *Journal, cmd Command, interrupted, adopted bool) error {
+	if interrupted {
+		return j.Record(cmd.Name, StepInterrupted, cmd.Stderr)
+	}
+	if !cmd.Succeed {
+		return j.Record(cmd.Name, StepFailed, cmd.Stderr)
+	}
+
+	status := StepDone
+	if adopted {
+		status = StepAdopted
+	}
+	err := j.Record(cmd.Name, status, nil)
+	if err != nil {
+		return err
+	}
+	return cs.C.GenerateConfig()
+}
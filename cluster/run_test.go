@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestRunAlreadyExists(t *testing.T) {
+	tests := []struct {
+		name       string
+		adopt      bool
+		wantErr    bool
+		wantStatus string
+		completed  []string
+	}{
+		{"fresh run fails", false, true, StepFailed, []string{"create-a"}},
+		{"resumed run adopts", true, false, StepAdopted, []string{"create-a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFakeExecutor(
+				FakeResponse{RootCmd: "gcloud", Args: []string{"create", "a"}},
+				FakeResponse{RootCmd: "gcloud", Args: []string{"create", "b"}, ExitCode: 1, Stderr: "ERROR: (gcloud) ALREADY_EXISTS: b"},
+			)
+			c := &Cluster{Name: "demo", Exec: f, ConfPath: tempDir(t)}
+			c.Journal = NewJournal(c.ConfPath)
+
+			cs := NewCmdSet(c, "create")
+			cs.Adopt = tt.adopt
+			cs.Concurrency = 1
+			cs.AddCmd(Command{Name: "create-a", RootCmd: "gcloud", Args: []string{"create", "a"}})
+			cs.AddCmd(Command{Name: "create-b", RootCmd: "gcloud", Args: []string{"create", "b"}, DependsOn: []string{"create-a"}})
+
+			err := cs.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run = %v, want error %v", err, tt.wantErr)
+			}
+
+			last := c.Journal.Entries[len(c.Journal.Entries)-1]
+			if last.Step != "create-b" || last.Status != tt.wantStatus {
+				t.Errorf("journaled %s as %s, want create-b as %s", last.Step, last.Status, tt.wantStatus)
+			}
+			if got := c.Journal.Completed(); !reflect.DeepEqual(got, tt.completed) {
+				t.Errorf("Completed = %v, want %v", got, tt.completed)
+			}
+			if got := c.Journal.Done("create-b"); got != tt.adopt {
+				t.Errorf("Done(create-b) = %v, want %v", got, tt.adopt)
+			}
+
+			var runErr *RunError
+			if tt.wantErr && (!errors.As(err, &runErr) || !reflect.DeepEqual(runErr.Failed, []string{"create-b"})) {
+				t.Errorf("Run = %v, want create-b failed", err)
+			}
+		})
+	}
+}
+
+func names(cmds []Command) []string {
+	var ns []string
+	for _, c := range cmds {
+		ns = append(ns, c.Name)
+	}
+	return ns
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmds    []Command
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "dependencies first",
+			cmds: []Command{
+				{Name: "c", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+				{Name: "a"},
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "independent keep their order",
+			cmds: []Command{
+				{Name: "x"},
+				{Name: "b", DependsOn: []string{"a"}},
+				{Name: "a"},
+				{Name: "y"},
+			},
+			want: []string{"x", "a", "y", "b"},
+		},
+		{
+			name: "internal commands are left out",
+			cmds: []Command{
+				{Name: "login", Internal: true},
+				{Name: "a", DependsOn: []string{"login"}},
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "cycle",
+			cmds: []Command{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+				{Name: "c"},
+			},
+			wantErr: "cyclic dependency between commands a, b",
+		},
+		{
+			name:    "unknown dependency",
+			cmds:    []Command{{Name: "a", DependsOn: []string{"nope"}}},
+			wantErr: "a: no command found of name nope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := NewCmdSet(nil, "test")
+			for _, cmd := range tt.cmds {
+				cs.AddCmd(cmd)
+			}
+
+			order, err := cs.Order()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Order = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(order); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSkipsDependentsOfFailedCommands(t *testing.T) {
+	f := NewFakeExecutor(
+		FakeResponse{RootCmd: "fail", ExitCode: 1, Stderr: "boom"},
+		FakeResponse{RootCmd: "ok"},
+	)
+	cs := NewCmdSet(&Cluster{Exec: f}, "test")
+	cs.AddCmd(Command{Name: "a", RootCmd: "fail"})
+	cs.AddCmd(Command{Name: "b", RootCmd: "ok", DependsOn: []string{"a"}})
+	cs.AddCmd(Command{Name: "c", RootCmd: "ok", DependsOn: []string{"b"}})
+	cs.AddCmd(Command{Name: "d", RootCmd: "ok"})
+	cs.AddCmd(Command{Name: "e", RootCmd: "ok", DependsOn: []string{"d"}})
+
+	err := cs.Run(context.Background())
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("Run = %v, want a RunError", err)
+	}
+	if !reflect.DeepEqual(runErr.Failed, []string{"a"}) || !reflect.DeepEqual(runErr.Skipped, []string{"b", "c"}) {
+		t.Errorf("failed %v, skipped %v, want [a] and [b c]", runErr.Failed, runErr.Skipped)
+	}
+	if n := f.Called("ok"); n != 2 {
+		t.Errorf("%d commands run besides the failed one, want d and e", n)
+	}
+	for name, want := range map[string]bool{"a": false, "b": false, "c": false, "d": true, "e": true} {
+		if got := cs.CmdMap[name].Succeed; got != want {
+			t.Errorf("%s succeeded = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// blockingExecutor blocks every process until ctx is done and records how
+// many run at once.
+type blockingExecutor struct {
+	mu      sync.Mutex
+	running int
+	max     int
+	started chan string
+}
+
+func (b *blockingExecutor) Exec(ctx context.Context, p Process) (ProcessResult, error) {
+	b.mu.Lock()
+	b.running++
+	if b.running > b.max {
+		b.max = b.running
+	}
+	b.mu.Unlock()
+	b.started <- p.Name
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	b.running--
+	b.mu.Unlock()
+	return ProcessResult{ExitCode: -1}, ctx.Err()
+}
+
+func TestRunCancel(t *testing.T) {
+	e := &blockingExecutor{started: make(chan string, 10)}
+	cs := NewCmdSet(&Cluster{Exec: e}, "test")
+	cs.Concurrency = 2
+	for _, name := range []string{"a", "b", "c"} {
+		cs.AddCmd(Command{Name: name, RootCmd: "x"})
+	}
+	cs.AddCmd(Command{Name: "d", RootCmd: "x", DependsOn: []string{"a"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-e.started
+		<-e.started
+		cancel()
+	}()
+
+	err := cs.Run(ctx)
+	var runErr *RunError
+	if !errors.As(err, &runErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want a cancelled RunError", err)
+	}
+	sort.Strings(runErr.Interrupted)
+	if !reflect.DeepEqual(runErr.Interrupted, []string{"a", "b"}) {
+		t.Errorf("interrupted %v, want [a b]", runErr.Interrupted)
+	}
+	if e.max != 2 {
+		t.Errorf("%d commands ran at once, want the concurrency of 2", e.max)
+	}
+	if len(e.started) != 0 {
+		t.Errorf("commands started after cancelling: %d", len(e.started))
+	}
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// SchemaVersion is the version of the config written by this kmanager.
+// Configs without a version predate versioning and count as version 0.
+const SchemaVersion = 2
+
+// migration rewrites a decoded config of one schema version into the next.
+type migration func(c map[string]interface{}) error
+
+// migrations[i] migrates a config of version i to version i+1. Add a
+// function here, and bump SchemaVersion, whenever a change to Cluster would
+// make older configs read differently.
+var migrations = []migration{
+	migrateV1,
+	migrateV2,
+}
+
+// migrateV1 fills in the settings which were hardcoded before they became
+// configurable, so clusters created back then keep their shape.
+func migrateV1(c map[string]interface{}) error {
+	if _, ok := c["location_mode"]; !ok {
+		c["location_mode"] = LocationZonal
+	}
+	if _, ok := c["nodes"]; !ok {
+		c["nodes"] = DefaultNodeConfig()
+	}
+	return nil
+}
+
+// migrateV2 makes config_path relative to the cluster dir, so the config
+// dir can be moved. Paths elsewhere are kept as they are.
+func migrateV2(c map[string]interface{}) error {
+	path, _ := c["config_path"].(string)
+	name, _ := c["cluster_name"].(string)
+	if path == "" || (filepath.IsAbs(path) && filepath.Base(path) == name) {
+		c["config_path"] = "."
+	}
+	return nil
+}
+
+// Migrate brings the config b up to SchemaVersion, returning it along with
+// the version it had. A config of the current version is returned as is.
+func Migrate(b []byte) ([]byte, int, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var c map[string]interface{}
+	err := d.Decode(&c)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid config: %v", err)
+	}
+
+	version := 0
+	if v, ok := c["schema_version"]; ok {
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid config: schema_version must be a number, got %v", v)
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid config: schema_version must be an integer, got %v", n)
+		}
+		version = int(i)
+	}
+
+	switch {
+	case version == SchemaVersion:
+		return b, version, nil
+	case version > SchemaVersion:
+		return nil, version, fmt.Errorf("config has schema version %d but this kmanager only knows up to %d, please upgrade kmanager", version, SchemaVersion)
+	case version < 0:
+		return nil, version, fmt.Errorf("invalid config: negative schema_version %d", version)
+	}
+
+	for v := version; v < SchemaVersion; v++ {
+		err = migrations[v](c)
+		if err != nil {
+			return nil, version, fmt.Errorf("migrating config from schema version %d to %d: %v", v, v+1, err)
+		}
+	}
+	c["schema_version"] = SchemaVersion
+
+	b, err = json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return nil, version, err
+	}
+	return b, version, nil
+}
@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		version      int
+		locationMode string
+		numNodes     int
+		confPath     string
+		wantErr      string
+	}{
+		{
+			name:         "unversioned",
+			config:       `{"cluster_name":"demo","config_path":"/home/me/.kmanager/demo"}`,
+			locationMode: LocationZonal,
+			numNodes:     DefaultNodeConfig().NumNodes,
+			confPath:     ".",
+		},
+		{
+			name:         "version 1 keeps its settings",
+			config:       `{"schema_version":1,"cluster_name":"demo","config_path":"/home/me/.kmanager/demo","location_mode":"regional","nodes":{"num_nodes":5}}`,
+			version:      1,
+			locationMode: LocationRegional,
+			numNodes:     5,
+			confPath:     ".",
+		},
+		{
+			name:         "version 1 without config path",
+			config:       `{"schema_version":1,"cluster_name":"demo","location_mode":"zonal"}`,
+			version:      1,
+			locationMode: LocationZonal,
+			confPath:     ".",
+		},
+		{
+			name:         "version 1 elsewhere keeps its path",
+			config:       `{"schema_version":1,"cluster_name":"demo","config_path":"/srv/clusters/other","location_mode":"zonal"}`,
+			version:      1,
+			locationMode: LocationZonal,
+			confPath:     "/srv/clusters/other",
+		},
+		{
+			name:         "current",
+			config:       `{"schema_version":2,"cluster_name":"demo","config_path":".","location_mode":"zonal"}`,
+			version:      2,
+			locationMode: LocationZonal,
+			confPath:     ".",
+		},
+		{
+			name:    "newer",
+			config:  `{"schema_version":3,"cluster_name":"demo"}`,
+			version: 3,
+			wantErr: "please upgrade kmanager",
+		},
+		{
+			name:    "negative",
+			config:  `{"schema_version":-1,"cluster_name":"demo"}`,
+			version: -1,
+			wantErr: "negative schema_version",
+		},
+		{
+			name:    "fractional",
+			config:  `{"schema_version":1.5,"cluster_name":"demo"}`,
+			wantErr: "must be an integer",
+		},
+		{
+			name:    "string",
+			config:  `{"schema_version":"1","cluster_name":"demo"}`,
+			wantErr: "must be a number",
+		},
+		{
+			name:    "not json",
+			config:  `schema_version: 1`,
+			wantErr: "invalid config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, version, err := Migrate([]byte(tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Migrate = %v, want an error containing %q", err, tt.wantErr)
+				}
+				if version != tt.version {
+					t.Errorf("version = %d, want %d", version, tt.version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.version {
+				t.Errorf("version = %d, want %d", version, tt.version)
+			}
+
+			var c Cluster
+			err = json.Unmarshal(b, &c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.SchemaVersion != SchemaVersion {
+				t.Errorf("migrated schema_version = %d, want %d", c.SchemaVersion, SchemaVersion)
+			}
+			if c.Name != "demo" {
+				t.Errorf("migrated cluster_name = %q, want demo", c.Name)
+			}
+			if c.LocationMode != tt.locationMode || c.Nodes.NumNodes != tt.numNodes || c.ConfPath != tt.confPath {
+				t.Errorf("migrated to location_mode %q, num_nodes %d, config_path %q, want %q, %d, %q",
+					c.LocationMode, c.Nodes.NumNodes, c.ConfPath, tt.locationMode, tt.numNodes, tt.confPath)
+			}
+
+			again, _, err := Migrate(b)
+			if err != nil || string(again) != string(b) {
+				t.Errorf("migrating a migrated config changed it: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/urvil38/kmanager/questions"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SpecAPIVersion = "kmanager/v1alpha1"
+	SpecKind       = "Cluster"
+)
+
+// Spec is the versioned, declarative description of a cluster used by
+// 'kmanager create -f'. Being YAML, a spec may be written as JSON too.
+type Spec struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   SpecMetadata `yaml:"metadata"`
+	Spec       ClusterSpec  `yaml:"spec"`
+}
+
+type SpecMetadata struct {
+	Name string `yaml:"name"`
+}
+
+// ClusterSpec describes the cluster. LocationMode is either zonal, the
+// default, or regional. WorkloadIdentity replaces service account keys by
+// Workload Identity. Labels are stamped on every resource besides the ones
+// kmanager sets itself.
+type ClusterSpec struct {
+	DNSName          string            `yaml:"dnsName"`
+	Project          string            `yaml:"project"`
+	Account          string            `yaml:"account"`
+	Region           string            `yaml:"region"`
+	Zone             string            `yaml:"zone"`
+	LocationMode     string            `yaml:"locationMode"`
+	NodeLocations    []string          `yaml:"nodeLocations"`
+	WorkloadIdentity bool              `yaml:"workloadIdentity"`
+	Labels           map[string]string `yaml:"labels"`
+	Nodes            NodeConfig        `yaml:"nodes"`
+	KubeApps         KubeAppOptions    `yaml:"kubeapps"`
+}
+
+// FieldError is a validation error of a single field of a Spec.
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (f FieldError) Error() string {
+	return f.Field + ": " + f.Msg
+}
+
+// SpecErrors holds every validation error found in a Spec.
+type SpecErrors []FieldError
+
+func (s SpecErrors) Error() string {
+	msgs := make([]string, 0, len(s))
+	for _, f := range s {
+		msgs = append(msgs, f.Error())
+	}
+	return "invalid cluster spec:\n  " + strings.Join(msgs, "\n  ")
+}
+
+// LoadSpec reads and validates the spec file at path.
+func LoadSpec(path string) (*Spec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSpec(b)
+}
+
+// ParseSpec decodes and validates a spec. Unknown fields are rejected so
+// typos don't silently fall back to defaults.
+func ParseSpec(b []byte) (*Spec, error) {
+	// fields missing from the spec keep their defaults
+	s := Spec{Spec: ClusterSpec{LocationMode: LocationZonal, Nodes: DefaultNodeConfig()}}
+	err := yaml.UnmarshalStrict(b, &s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster spec: %v", err)
+	}
+
+	err = s.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Validate checks every field of the spec and reports all problems at
+// once.
+func (s *Spec) Validate() error {
+	var errs SpecErrors
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if s.APIVersion != SpecAPIVersion {
+		add("apiVersion", "must be %q, got %q", SpecAPIVersion, s.APIVersion)
+	}
+	if s.Kind != SpecKind {
+		add("kind", "must be %q, got %q", SpecKind, s.Kind)
+	}
+	if !questions.IsValidClusterName(s.Metadata.Name) {
+		add("metadata.name", "%q is not a valid name, it can contain [ A-Z a-z 1-9 or `-` ]", s.Metadata.Name)
+	}
+
+	cs := s.Spec
+	if !questions.IsValidDomainName(cs.DNSName) {
+		add("spec.dnsName", "%q is not a valid domain name", cs.DNSName)
+	}
+	if cs.Project == "" {
+		add("spec.project", "is required")
+	}
+	if cs.Region == "" {
+		add("spec.region", "is required")
+	}
+	switch cs.LocationMode {
+	case "", LocationZonal:
+		if cs.Zone == "" {
+			add("spec.zone", "is required for a %s cluster", LocationZonal)
+		}
+	case LocationRegional:
+	default:
+		add("spec.locationMode", "must be one of %s, got %q", strings.Join(LocationModes, ", "), cs.LocationMode)
+	}
+	if cs.Zone != "" && cs.Region != "" && !strings.HasPrefix(cs.Zone, cs.Region+"-") {
+		add("spec.zone", "%q is not a zone of region %q", cs.Zone, cs.Region)
+	}
+	for i, l := range cs.NodeLocations {
+		if cs.Region != "" && !strings.HasPrefix(l, cs.Region+"-") {
+			add(fmt.Sprintf("spec.nodeLocations[%d]", i), "%q is not a zone of region %q", l, cs.Region)
+		}
+	}
+
+	if cs.Nodes.MachineType == "" {
+		add("spec.nodes.machineType", "is required")
+	}
+	if cs.Nodes.NumNodes < 1 {
+		add("spec.nodes.numNodes", "must be at least 1, got %d", cs.Nodes.NumNodes)
+	}
+	if cs.Nodes.DiskSizeGB < 10 {
+		add("spec.nodes.diskSizeGB", "must be at least 10, got %d", cs.Nodes.DiskSizeGB)
+	}
+	if cs.Nodes.DiskType == "" {
+		add("spec.nodes.diskType", "is required")
+	}
+	if cs.Nodes.ImageType == "" {
+		add("spec.nodes.imageType", "is required")
+	}
+	if len(cs.Nodes.Scopes) == 0 {
+		add("spec.nodes.scopes", "at least one scope is required")
+	}
+
+	if err := ValidateLabels(cs.Labels); err != nil {
+		add("spec.labels", "%v", err)
+	}
+
+	if cs.KubeApps.Index != "" {
+		u, err := url.Parse(cs.KubeApps.Index)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			add("spec.kubeapps.index", "%q is not an http(s) url", cs.KubeApps.Index)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Cluster returns the cluster described by the spec. It is set up to run
+// without prompting.
+func (s *Spec) Cluster() *Cluster {
+	c := &Cluster{
+		Name:              s.Metadata.Name,
+		GcloudProjectName: s.Spec.Project,
+		Account:           s.Spec.Account,
+		Region:            s.Spec.Region,
+		Zone:              s.Spec.Zone,
+		LocationMode:      s.Spec.LocationMode,
+		NodeLocations:     s.Spec.NodeLocations,
+		WorkloadIdentity:  s.Spec.WorkloadIdentity,
+		Labels:            s.Spec.Labels,
+		DNSName:           s.Spec.DNSName,
+		Nodes:             s.Spec.Nodes,
+		KubeAppOptions:    s.Spec.KubeApps,
+		NonInteractive:    true,
+	}
+	c.GetStorageOpts()
+	c.GetServiceAccountOpts()
+
+	return c
+}
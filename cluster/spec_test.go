@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const validSpec = `apiVersion: kmanager/v1alpha1
+kind: Cluster
+metadata:
+  name: demo
+spec:
+  dnsName: demo.example.com
+  project: proj
+  region: us-central1
+  zone: us-central1-a
+`
+
+func TestParseSpec(t *testing.T) {
+	s, err := ParseSpec([]byte(validSpec + "  labels:\n    team: platform\n"))
+	if err != nil {
+		t.Fatalf("ParseSpec: %v", err)
+	}
+
+	c := s.Cluster()
+	if c.Name != "demo" || c.GcloudProjectName != "proj" || c.Zone != "us-central1-a" || c.LocationMode != LocationZonal {
+		t.Errorf("cluster %+v does not match the spec", c)
+	}
+	if !reflect.DeepEqual(c.Nodes, DefaultNodeConfig()) {
+		t.Errorf("nodes %+v, want the defaults %+v", c.Nodes, DefaultNodeConfig())
+	}
+	if c.Labels["team"] != "platform" || !c.NonInteractive {
+		t.Errorf("cluster %+v lacks the labels or prompts", c)
+	}
+}
+
+func TestParseSpecUnknownField(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  string
+		field string
+	}{
+		{"top level", validSpec + "status: {}\n", "field status not found"},
+		{"spec", validSpec + "  zonee: us-central1-b\n", "field zonee not found"},
+		{"nodes", validSpec + "  nodes:\n    numNode: 3\n", "field numNode not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSpec([]byte(tt.spec))
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("ParseSpec = %v, want %q", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		fields []string
+	}{
+		{
+			name:   "missing required fields",
+			spec:   "apiVersion: kmanager/v1alpha1\nkind: Cluster\nspec:\n  dnsName: demo.example.com\n",
+			fields: []string{"metadata.name", "spec.project", "spec.region", "spec.zone"},
+		},
+		{
+			name:   "wrong version and kind",
+			spec:   strings.Replace(strings.Replace(validSpec, "v1alpha1", "v1", 1), "kind: Cluster", "kind: Pod", 1),
+			fields: []string{"apiVersion", "kind"},
+		},
+		{
+			name:   "bad location",
+			spec:   strings.Replace(validSpec, "zone: us-central1-a", "zone: europe-west1-b\n  locationMode: global\n  nodeLocations: [us-central1-a, us-east1-b]", 1),
+			fields: []string{"spec.locationMode", "spec.zone", "spec.nodeLocations[1]"},
+		},
+		{
+			name:   "bad nodes",
+			spec:   validSpec + "  nodes:\n    machineType: \"\"\n    numNodes: 0\n    diskSizeGB: 5\n    scopes: []\n",
+			fields: []string{"spec.nodes.machineType", "spec.nodes.numNodes", "spec.nodes.diskSizeGB", "spec.nodes.scopes"},
+		},
+		{
+			name:   "bad dns name, labels and index",
+			spec:   strings.Replace(validSpec, "demo.example.com", "not a domain", 1) + "  labels:\n    managed-by: me\n  kubeapps:\n    index: ftp://example.com/index.yaml\n",
+			fields: []string{"spec.dnsName", "spec.labels", "spec.kubeapps.index"},
+		},
+		{
+			name: "regional without zone",
+			spec: strings.Replace(validSpec, "zone: us-central1-a", "locationMode: regional", 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSpec([]byte(tt.spec))
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Errorf("ParseSpec: %v", err)
+				}
+				return
+			}
+
+			var errs SpecErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("ParseSpec = %v, want the invalid fields", err)
+			}
+			var fields []string
+			for _, f := range errs {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("invalid fields %q, want %q\n%v", fields, tt.fields, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"github.com/urvil38/kmanager/config"
+)
+
+// stateRef ties a cluster to the state store it was loaded from or is
+// created in, along with the version of its config last seen. It is shared
+// by every copy of the cluster, so each write is conditional on the one
+// before.
+type stateRef struct {
+	store *config.StateStore
+
+	mu      sync.Mutex
+	version string
+}
+
+// UseStore attaches c to store as a new cluster, its first GenerateConfig
+// fails when a cluster of the same name exists already.
+func (c *Cluster) UseStore(store *config.StateStore) {
+	c.state = &stateRef{store: store}
+}
+
+func (r *stateRef) put(name string, b []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	version, err := r.store.Put(context.Background(), name, b, r.version)
+	if err != nil {
+		return err
+	}
+	r.version = version
+	return nil
+}
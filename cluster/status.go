@@ -0,0 +1,446 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// States of a resource reported by Status.
+const (
+	StatusPresent = "present"
+	StatusDrifted = "drifted"
+	StatusMissing = "missing"
+	// StatusUnknown is reported when the resource could not be checked,
+	// e.g. for lack of permissions.
+	StatusUnknown = "unknown"
+)
+
+// ResourceStatus tells how a resource recorded in the config of a cluster
+// compares to what exists.
+type ResourceStatus struct {
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Drift  []string `json:"drift,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// statusCheck gathers the results of Status.
+type statusCheck struct {
+	c         *Cluster
+	e         Executor
+	resources []ResourceStatus
+}
+
+func (s *statusCheck) add(kind, name string, drift []string) {
+	status := StatusPresent
+	if len(drift) > 0 {
+		status = StatusDrifted
+	}
+	s.resources = append(s.resources, ResourceStatus{Kind: kind, Name: name, Status: status, Drift: drift})
+}
+
+// fail records the failed lookup of a resource, which is missing when the
+// lookup says so.
+func (s *statusCheck) fail(kind, name string, err error) {
+	status := StatusUnknown
+	if errors.Is(err, ErrNotFound) {
+		status = StatusMissing
+	}
+	s.resources = append(s.resources, ResourceStatus{Kind: kind, Name: name, Status: status, Error: errorSummary(err)})
+}
+
+// query runs a read only lookup without echoing it, so the output of
+// Status can be consumed by scripts.
+func (s *statusCheck) query(ctx context.Context, name, rootCmd string, args ...string) (string, error) {
+	p := Process{Name: name, RootCmd: rootCmd, Args: args, Env: s.c.env(), ReadOnly: true}
+	res, err := s.e.Exec(ctx, p)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return res.Stdout, newCommandError(p, res, err)
+	}
+	return res.Stdout, nil
+}
+
+func (s *statusCheck) queryJSON(ctx context.Context, v interface{}, name, rootCmd string, args ...string) error {
+	out, err := s.query(ctx, name, rootCmd, args...)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal([]byte(out), v)
+	if err != nil {
+		return fmt.Errorf("%s: unexpected output: %v", name, err)
+	}
+	return nil
+}
+
+// Status checks every resource recorded in c: the GKE cluster, the
+// buckets, the DNS zone, the service accounts and their IAM bindings and
+// the kubeapps. The lookups are run through c.Exec.
+func (c *Cluster) Status(ctx context.Context) []ResourceStatus {
+	s := &statusCheck{c: c, e: c.Exec}
+	if s.e == nil {
+		s.e = DefaultExecutor
+	}
+
+	gkeFound := s.checkKubernetesCluster(ctx)
+	s.checkBucket(ctx, c.Storage.SourceCodeBucket)
+	s.checkBucket(ctx, c.Storage.CloudBuildBucket)
+	s.checkDNSZone(ctx)
+
+	for _, sa := range []string{c.ServiceAccount.DNS, c.ServiceAccount.CloudBuild, c.ServiceAccount.Storage} {
+		s.checkServiceAccount(ctx, sa)
+	}
+	s.checkProjectBindings(ctx, map[string]string{
+		c.ServiceAccount.DNS:        "roles/dns.admin",
+		c.ServiceAccount.CloudBuild: "roles/cloudbuild.builds.editor",
+	})
+	s.checkBucketBinding(ctx, c.Storage.SourceCodeBucket, c.ServiceAccount.Storage, "roles/storage.objectCreator")
+	s.checkBucketBinding(ctx, c.Storage.CloudBuildBucket, c.ServiceAccount.Storage, "roles/storage.objectViewer")
+	if c.WorkloadIdentity {
+		for _, ksa := range []KubeServiceAccount{c.DNSKubeServiceAccount(), c.CloudBuildKubeServiceAccount(), c.StorageKubeServiceAccount()} {
+			s.checkWorkloadIdentityBinding(ctx, ksa)
+		}
+	}
+
+	s.checkKubeApps(ctx, gkeFound)
+	return s.resources
+}
+
+// gkeCluster is the part of 'gcloud container clusters describe' compared
+// with the config, or imported into it.
+type gkeCluster struct {
+	Name                   string            `json:"name"`
+	Status                 string            `json:"status"`
+	Location               string            `json:"location"`
+	Locations              []string          `json:"locations"`
+	ResourceLabels         map[string]string `json:"resourceLabels"`
+	WorkloadIdentityConfig *struct {
+		WorkloadPool string `json:"workloadPool"`
+	} `json:"workloadIdentityConfig"`
+	NodePools []gkeNodePool `json:"nodePools"`
+}
+
+type gkeNodePool struct {
+	Name             string `json:"name"`
+	InitialNodeCount int    `json:"initialNodeCount"`
+	Autoscaling      *struct {
+		Enabled      bool `json:"enabled"`
+		MinNodeCount int  `json:"minNodeCount"`
+		MaxNodeCount int  `json:"maxNodeCount"`
+	} `json:"autoscaling"`
+	Config struct {
+		MachineType string            `json:"machineType"`
+		DiskSizeGb  int               `json:"diskSizeGb"`
+		DiskType    string            `json:"diskType"`
+		ImageType   string            `json:"imageType"`
+		Preemptible bool              `json:"preemptible"`
+		Spot        bool              `json:"spot"`
+		OauthScopes []string          `json:"oauthScopes"`
+		Labels      map[string]string `json:"labels"`
+		Taints      []struct {
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Effect string `json:"effect"`
+		} `json:"taints"`
+	} `json:"config"`
+}
+
+func (s *statusCheck) checkKubernetesCluster(ctx context.Context) bool {
+	c := s.c
+	args := append([]string{"container", "clusters", "describe", c.Name, "--project", c.GcloudProjectName}, c.LocationArgs()...)
+	args = append(args, "--format", "json")
+
+	var gke gkeCluster
+	err := s.queryJSON(ctx, &gke, "describe-kubernetes-cluster", "gcloud", args...)
+	if err != nil {
+		s.fail("kubernetes-cluster", c.Name, err)
+		return false
+	}
+
+	var drift []string
+	if gke.Status != "RUNNING" {
+		drift = append(drift, fmt.Sprintf("status is %s", gke.Status))
+	}
+	drift = append(drift, labelDrift(c.ResourceLabels(), gke.ResourceLabels)...)
+
+	hasPool := gke.WorkloadIdentityConfig != nil && gke.WorkloadIdentityConfig.WorkloadPool != ""
+	if c.WorkloadIdentity && !hasPool {
+		drift = append(drift, "workload identity is disabled")
+	} else if !c.WorkloadIdentity && hasPool {
+		drift = append(drift, "workload identity is enabled")
+	}
+
+	if len(c.NodeLocations) > 0 {
+		want := append([]string{}, c.NodeLocations...)
+		got := append([]string{}, gke.Locations...)
+		sort.Strings(want)
+		sort.Strings(got)
+		if strings.Join(want, ",") != strings.Join(got, ",") {
+			drift = append(drift, fmt.Sprintf("node locations are %s, expected %s", strings.Join(got, ","), strings.Join(want, ",")))
+		}
+	}
+
+	pools := make(map[string]int)
+	for i, p := range gke.NodePools {
+		pools[p.Name] = i
+	}
+
+	if i, ok := pools["default-pool"]; ok {
+		n, got := c.Nodes, gke.NodePools[i].Config
+		if got.MachineType != n.MachineType {
+			drift = append(drift, fmt.Sprintf("default-pool: machine type is %s, expected %s", got.MachineType, n.MachineType))
+		}
+		if got.DiskSizeGb != n.DiskSizeGB {
+			drift = append(drift, fmt.Sprintf("default-pool: disk size is %dGB, expected %dGB", got.DiskSizeGb, n.DiskSizeGB))
+		}
+		if got.DiskType != n.DiskType {
+			drift = append(drift, fmt.Sprintf("default-pool: disk type is %s, expected %s", got.DiskType, n.DiskType))
+		}
+		if !sameImageType(got.ImageType, n.ImageType) {
+			drift = append(drift, fmt.Sprintf("default-pool: image type is %s, expected %s", got.ImageType, n.ImageType))
+		}
+		if got.Preemptible != n.Preemptible {
+			drift = append(drift, fmt.Sprintf("default-pool: preemptible is %t, expected %t", got.Preemptible, n.Preemptible))
+		}
+	} else {
+		drift = append(drift, "default-pool is missing")
+	}
+
+	for _, p := range c.NodePools {
+		i, ok := pools[p.Name]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("node pool %s is missing", p.Name))
+			continue
+		}
+		got := gke.NodePools[i].Config
+		if got.MachineType != p.MachineType {
+			drift = append(drift, fmt.Sprintf("%s: machine type is %s, expected %s", p.Name, got.MachineType, p.MachineType))
+		}
+		if got.Spot != p.Spot || got.Preemptible != p.Preemptible {
+			drift = append(drift, fmt.Sprintf("%s: spot %t and preemptible %t, expected %t and %t", p.Name, got.Spot, got.Preemptible, p.Spot, p.Preemptible))
+		}
+	}
+
+	s.add("kubernetes-cluster", c.Name, drift)
+	return true
+}
+
+func (s *statusCheck) checkBucket(ctx context.Context, bucket string) {
+	out, err := s.query(ctx, "describe-storage-bucket", "gsutil", "label", "get", "gs://"+bucket)
+	if err != nil {
+		s.fail("storage-bucket", bucket, err)
+		return
+	}
+
+	// gsutil answers in plain text when the bucket has no labels
+	labels := map[string]string{}
+	if strings.HasPrefix(strings.TrimSpace(out), "{") {
+		err = json.Unmarshal([]byte(out), &labels)
+		if err != nil {
+			s.fail("storage-bucket", bucket, fmt.Errorf("unexpected labels: %v", err))
+			return
+		}
+	}
+
+	s.add("storage-bucket", bucket, labelDrift(s.c.ResourceLabels(), labels))
+}
+
+func (s *statusCheck) checkDNSZone(ctx context.Context) {
+	c := s.c
+	var zone struct {
+		DNSName string            `json:"dnsName"`
+		Labels  map[string]string `json:"labels"`
+	}
+	err := s.queryJSON(ctx, &zone, "describe-dns-zone", "gcloud", "dns", "managed-zones", "describe", c.Name, "--project", c.GcloudProjectName, "--format", "json")
+	if err != nil {
+		s.fail("dns-zone", c.Name, err)
+		return
+	}
+
+	var drift []string
+	if strings.TrimSuffix(zone.DNSName, ".") != strings.TrimSuffix(c.DNSName, ".") {
+		drift = append(drift, fmt.Sprintf("dns name is %s, expected %s", zone.DNSName, c.DNSName))
+	}
+	drift = append(drift, labelDrift(c.ResourceLabels(), zone.Labels)...)
+	s.add("dns-zone", c.Name, drift)
+}
+
+func (s *statusCheck) checkServiceAccount(ctx context.Context, email string) {
+	var sa struct {
+		Disabled bool `json:"disabled"`
+	}
+	err := s.queryJSON(ctx, &sa, "describe-service-account", "gcloud", "iam", "service-accounts", "describe", email, "--project", s.c.GcloudProjectName, "--format", "json")
+	if err != nil {
+		s.fail("service-account", email, err)
+		return
+	}
+
+	var drift []string
+	if sa.Disabled {
+		drift = append(drift, "service account is disabled")
+	}
+	s.add("service-account", email, drift)
+}
+
+// iamPolicy is the policy printed by gcloud and gsutil.
+type iamPolicy struct {
+	Bindings []struct {
+		Role    string   `json:"role"`
+		Members []string `json:"members"`
+	} `json:"bindings"`
+}
+
+func (p iamPolicy) has(member, role string) bool {
+	for _, b := range p.Bindings {
+		if b.Role != role {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkBinding records whether the binding of member to role on resource
+// is part of policy, or the error fetching policy. A binding lost from the
+// policy of a resource which is still there is drift of that policy.
+func (s *statusCheck) checkBinding(policy iamPolicy, err error, resource, member, role string) {
+	name := fmt.Sprintf("%s %s on %s", member, role, resource)
+	if err != nil {
+		// the binding can't be checked, whether or not resource exists
+		s.resources = append(s.resources, ResourceStatus{Kind: "iam-binding", Name: name, Status: StatusUnknown, Error: errorSummary(err)})
+		return
+	}
+	var drift []string
+	if !policy.has(member, role) {
+		drift = append(drift, "binding is missing from the policy")
+	}
+	s.add("iam-binding", name, drift)
+}
+
+func (s *statusCheck) checkProjectBindings(ctx context.Context, roles map[string]string) {
+	project := s.c.GcloudProjectName
+	var policy iamPolicy
+	err := s.queryJSON(ctx, &policy, "get-project-iam-policy", "gcloud", "projects", "get-iam-policy", project, "--format", "json")
+	for _, sa := range sortedKeys(roles) {
+		s.checkBinding(policy, err, "project "+project, "serviceAccount:"+sa, roles[sa])
+	}
+}
+
+func (s *statusCheck) checkBucketBinding(ctx context.Context, bucket, serviceAccount, role string) {
+	var policy iamPolicy
+	err := s.queryJSON(ctx, &policy, "get-storage-bucket-iam-policy", "gsutil", "iam", "get", "gs://"+bucket)
+	s.checkBinding(policy, err, "gs://"+bucket, "serviceAccount:"+serviceAccount, role)
+}
+
+func (s *statusCheck) checkWorkloadIdentityBinding(ctx context.Context, ksa KubeServiceAccount) {
+	var policy iamPolicy
+	err := s.queryJSON(ctx, &policy, "get-service-account-iam-policy", "gcloud", "iam", "service-accounts", "get-iam-policy", ksa.GSA, "--project", s.c.GcloudProjectName, "--format", "json")
+	s.checkBinding(policy, err, ksa.GSA, ksa.Member(s.c.WorkloadPool()), workloadIdentityUserRole)
+}
+
+// checkKubeApps compares the manifest rendered for every kubeapp with the
+// live objects. Without the GKE cluster they are all gone.
+func (s *statusCheck) checkKubeApps(ctx context.Context, gkeFound bool) {
+	c := s.c
+	if c.KubeAppConfig == nil {
+		return
+	}
+
+	var kubeErr error
+	if gkeFound {
+		kubeErr = c.EnsureKubeconfig(ctx)
+	}
+
+	for _, app := range c.KubeAppConfig.Apps {
+		if app.Deprecated || c.KubeAppOptions.excluded(app.Name) {
+			continue
+		}
+
+		if kubeErr != nil {
+			s.fail("kubeapp", app.Name, kubeErr)
+			continue
+		}
+		if !gkeFound {
+			s.resources = append(s.resources, ResourceStatus{Kind: "kubeapp", Name: app.Name, Status: StatusUnknown, Error: "kubernetes cluster is not available"})
+			continue
+		}
+
+		manifest := filepath.Join(c.ConfPath, app.Name+".yaml")
+		if _, err := os.Stat(manifest); err != nil {
+			s.resources = append(s.resources, ResourceStatus{Kind: "kubeapp", Name: app.Name, Status: StatusUnknown, Error: "no rendered manifest found at " + manifest})
+			continue
+		}
+
+		_, err := s.query(ctx, "get-kubeapp", "kubectl", "get", "-f", manifest, "-o", "name")
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) && strings.Contains(cmdErr.Stderr, "(NotFound)") {
+			s.resources = append(s.resources, ResourceStatus{Kind: "kubeapp", Name: app.Name, Status: StatusMissing, Error: errorSummary(err)})
+			continue
+		} else if err != nil {
+			s.fail("kubeapp", app.Name, err)
+			continue
+		}
+
+		// kubectl diff exits with 1 when the live objects differ
+		_, err = s.query(ctx, "diff-kubeapp", "kubectl", "diff", "-f", manifest)
+		if errors.As(err, &cmdErr) && cmdErr.ExitCode == 1 {
+			s.add("kubeapp", app.Name, []string{"live objects differ from the rendered manifest, see 'kubectl diff -f " + manifest + "'"})
+			continue
+		} else if err != nil {
+			s.fail("kubeapp", app.Name, err)
+			continue
+		}
+		s.add("kubeapp", app.Name, nil)
+	}
+}
+
+// sameImageType compares image types, GKE runs the plain COS and UBUNTU
+// images with containerd nowadays.
+func sameImageType(got, want string) bool {
+	trim := func(s string) string {
+		return strings.TrimSuffix(strings.ToUpper(s), "_CONTAINERD")
+	}
+	return trim(got) == trim(want)
+}
+
+// labelDrift lists the labels of want which got lost or changed.
+func labelDrift(want, got map[string]string) []string {
+	var drift []string
+	for _, k := range sortedKeys(want) {
+		v, ok := got[k]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("label %s is missing", k))
+		} else if v != want[k] {
+			drift = append(drift, fmt.Sprintf("label %s is %q, expected %q", k, v, want[k]))
+		}
+	}
+	return drift
+}
+
+// errorSummary shortens err to what went wrong, the kind of failure or
+// the last line of the output.
+func errorSummary(err error) string {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Kind != nil {
+		return cmdErr.Kind.Error()
+	}
+
+	msg := strings.TrimSpace(err.Error())
+	if i := strings.LastIndex(msg, "\n"); i != -1 {
+		return strings.TrimSpace(msg[i+1:])
+	}
+	return msg
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/cluster"
+	"github.com/urvil38/kmanager/config"
+)
+
+const (
+	configUsageStr        = "config"
+	configMigrateUsageStr = "migrate"
+	configAuditUsageStr   = "audit-perms"
+)
+
+type AuditPermsOptions struct {
+	Fix bool
+}
+
+func newAuditPermsOptions() *AuditPermsOptions {
+	return &AuditPermsOptions{}
+}
+
+// newConfigCmd represents the config command group
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   configUsageStr,
+		Short: "Maintain the configs of the clusters managed by kmanager",
+	}
+
+	cmd.AddCommand(
+		newConfigMigrateCmd(),
+		newConfigAuditPermsCmd(),
+	)
+	return cmd
+}
+
+func newConfigMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   configMigrateUsageStr,
+		Short: "Rewrite the config of every cluster in the current schema, keeping a backup of the old one",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := migrateConfigs(cmd.Context())
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while migrating configs:", err)
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+func newConfigAuditPermsCmd() *cobra.Command {
+	o := newAuditPermsOptions()
+
+	cmd := &cobra.Command{
+		Use:   configAuditUsageStr,
+		Short: "Report files in the kmanager config dir readable by others and unencrypted keys",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := auditPerms(*o)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *AuditPermsOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.Fix, "fix", false, "tighten the modes and encrypt the keys found")
+}
+
+func auditPerms(o AuditPermsOptions) error {
+	dir, err := config.KmanagerConfigPath()
+	if err != nil {
+		return err
+	}
+
+	issues, err := config.AuditPerms(dir)
+	if err != nil {
+		return err
+	}
+	if len(issues) == 0 {
+		color.HiGreen("no insecure files found in %s", dir)
+		return nil
+	}
+
+	left := 0
+	for _, i := range issues {
+		fmt.Printf("%s (%#o): %s\n", i.Path, i.Mode, i.Problem)
+		if !o.Fix {
+			left++
+			continue
+		}
+
+		err := i.Fix()
+		if err != nil {
+			fmt.Printf("  unable to fix: %v\n", err)
+			left++
+			continue
+		}
+		fmt.Println("  fixed")
+	}
+
+	if left > 0 {
+		if !o.Fix {
+			return fmt.Errorf("found %d insecure file(s), run 'kmanager config audit-perms --fix' to fix them", left)
+		}
+		return fmt.Errorf("%d insecure file(s) left", left)
+	}
+	return nil
+}
+
+func migrateConfigs(ctx context.Context) error {
+	store, err := config.Store()
+	if err != nil {
+		return err
+	}
+
+	names, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, name := range names {
+		err := migrateConfig(ctx, store, name)
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d config(s) could not be migrated", failed, len(names))
+	}
+	return nil
+}
+
+// migrateConfig rewrites the config of the named cluster in the current
+// schema. The old config is backed up first, the rewrite only succeeds if
+// nobody changed the config meanwhile.
+func migrateConfig(ctx context.Context, store *config.StateStore, name string) error {
+	ctx, unlock, err := lockCluster(ctx, name, "config migrate")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	b, version, err := store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	migrated, from, err := cluster.Migrate(b)
+	if err != nil {
+		return err
+	}
+	if from == cluster.SchemaVersion {
+		fmt.Printf("%s: up to date at schema version %d\n", name, from)
+		return nil
+	}
+
+	key, err := store.Backup(ctx, name, fmt.Sprintf("v%d", from), b)
+	if err != nil {
+		return fmt.Errorf("unable to back up config: %v", err)
+	}
+	if lb, ok := store.Backend.(*config.LocalBackend); ok {
+		key = filepath.Join(lb.Dir, filepath.FromSlash(key))
+	}
+
+	_, err = store.Put(ctx, name, migrated, version)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: migrated from schema version %d to %d, old config kept at %s\n", name, from, cluster.SchemaVersion, key)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newConfigCmd())
+}
@@ -2,12 +2,14 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/fatih/color"
 	"github.com/urvil38/kmanager/cluster"
 	"github.com/urvil38/kmanager/config"
 	"github.com/urvil38/kmanager/questions"
@@ -15,31 +17,149 @@ import (
 	"github.com/spf13/cobra"
 )
 
+type CreateOptions struct {
+	File        string
+	DryRun      bool
+	PlanFile    string
+	Parallelism int
+	Resume      string
+	StepTimeout time.Duration
+
+	RollbackOnFailure bool
+
+	LocationMode     string
+	NodeLocations    []string
+	WorkloadIdentity bool
+	Labels           map[string]string
+
+	// Nodes holds the node shape given by flags, only the flags named in
+	// nodeFlagsSet override the prompts or the spec file.
+	Nodes        cluster.NodeConfig
+	nodeFlagsSet map[string]bool
+}
+
+func newCreateOptions() *CreateOptions {
+	return &CreateOptions{
+		Nodes: cluster.DefaultNodeConfig(),
+	}
+}
+
+var nodeFlags = []string{"machine-type", "num-nodes", "disk-size", "disk-type", "image-type", "preemptible", "scopes"}
+
 // createCmd represents the create command
-var createCmd = &cobra.Command{
-	Use:   "create",
-	Short: "Create a new kubepaas cluster",
-	Run: func(cmd *cobra.Command, args []string) {
-		err := CreateCluster()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+func newCreateCmd() *cobra.Command {
+	o := newCreateOptions()
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a new kubepaas cluster",
+		Run: func(cmd *cobra.Command, args []string) {
+			o.nodeFlagsSet = map[string]bool{}
+			for _, name := range nodeFlags {
+				o.nodeFlagsSet[name] = cmd.Flags().Changed(name)
+			}
+
+			err := CreateCluster(cmd.Context(), cluster.DefaultExecutor, *o)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *CreateOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.File, "file", "f", "", "create the cluster described by this spec file without prompting")
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", false, "print every step of the creation without executing it")
+	cmd.Flags().StringVar(&o.PlanFile, "plan-file", "", "with --dry-run, also write the plan as JSON to this file")
+	cmd.Flags().StringVar(&o.Resume, "resume", "", "continue the interrupted creation of the named cluster")
+	cmd.Flags().BoolVar(&o.RollbackOnFailure, "rollback-on-failure", false, "tear down already created resources when a step fails")
+	cmd.Flags().IntVar(&o.Parallelism, "parallelism", cluster.DefaultConcurrency, "maximum number of independent steps run at once")
+	cmd.Flags().DurationVar(&o.StepTimeout, "step-timeout", 0, "maximum duration of a single step without a timeout of its own, 0 means no limit")
+
+	cmd.Flags().StringVar(&o.LocationMode, "location-mode", "", "zonal or regional, regional clusters replicate the control plane across the region")
+	cmd.Flags().StringSliceVar(&o.NodeLocations, "node-locations", nil, "zones to run the nodes in, defaults to the zone or all zones of the region")
+	cmd.Flags().StringToStringVar(&o.Labels, "labels", nil, "labels stamped on every resource of the cluster as key=value")
+	cmd.Flags().BoolVar(&o.WorkloadIdentity, "workload-identity", false, "let workloads act as the service accounts through Workload Identity instead of generating JSON keys")
+
+	cmd.Flags().StringVar(&o.Nodes.MachineType, "machine-type", o.Nodes.MachineType, "machine type of the nodes")
+	cmd.Flags().IntVar(&o.Nodes.NumNodes, "num-nodes", o.Nodes.NumNodes, "number of nodes")
+	cmd.Flags().IntVar(&o.Nodes.DiskSizeGB, "disk-size", o.Nodes.DiskSizeGB, "boot disk size of the nodes in GB")
+	cmd.Flags().StringVar(&o.Nodes.DiskType, "disk-type", o.Nodes.DiskType, "boot disk type of the nodes")
+	cmd.Flags().StringVar(&o.Nodes.ImageType, "image-type", o.Nodes.ImageType, "image type of the nodes")
+	cmd.Flags().BoolVar(&o.Nodes.Preemptible, "preemptible", o.Nodes.Preemptible, "use preemptible nodes")
+	cmd.Flags().StringSliceVar(&o.Nodes.Scopes, "scopes", o.Nodes.Scopes, "OAuth scopes granted to the nodes")
+}
+
+// nodesFromFlags reports whether any node shape flag was given.
+func (o CreateOptions) nodesFromFlags() bool {
+	for _, set := range o.nodeFlagsSet {
+		if set {
+			return true
 		}
-	},
+	}
+	return false
 }
 
-func CreateCluster() error {
-	c := new(cluster.Cluster)
+// applyNodeFlags overrides the fields of n given by flags.
+func (o CreateOptions) applyNodeFlags(n *cluster.NodeConfig) {
+	set := o.nodeFlagsSet
+	if set["machine-type"] {
+		n.MachineType = o.Nodes.MachineType
+	}
+	if set["num-nodes"] {
+		n.NumNodes = o.Nodes.NumNodes
+	}
+	if set["disk-size"] {
+		n.DiskSizeGB = o.Nodes.DiskSizeGB
+	}
+	if set["disk-type"] {
+		n.DiskType = o.Nodes.DiskType
+	}
+	if set["image-type"] {
+		n.ImageType = o.Nodes.ImageType
+	}
+	if set["preemptible"] {
+		n.Preemptible = o.Nodes.Preemptible
+	}
+	if set["scopes"] {
+		n.Scopes = o.Nodes.Scopes
+	}
+}
 
-	if err := survey.Ask(questions.ClusterName, &c.Name); err != nil {
-		return err
+// CreateCluster walks the user through creating a new kubepaas cluster. Every
+// external command is run through e. With o.DryRun set, mutating commands and
+// rendered manifests are only recorded and printed as a plan.
+func CreateCluster(ctx context.Context, e cluster.Executor, o CreateOptions) error {
+	var plan *cluster.Plan
+	if o.DryRun {
+		plan = cluster.NewPlan(e)
+		e = plan
+	}
+
+	if o.Resume != "" {
+		if o.File != "" {
+			return errors.New("--file can not be combined with --resume")
+		}
+		return resumeCluster(ctx, e, o)
 	}
 
-	if err := survey.Ask(questions.DomainName, &c.DNSName); err != nil {
+	c, err := newCluster(o)
+	if err != nil {
 		return err
 	}
+	c.Exec = e
 
-	confPath, err := config.CreateConfigDir(c.Name)
+	var confPath string
+	if o.DryRun {
+		plan.Cluster = c.Name
+		confPath, err = config.ClusterDir(c.Name)
+	} else {
+		confPath, err = config.CreateConfigDir(c.Name)
+	}
 	if err != nil {
 		return err
 	}
@@ -48,99 +168,281 @@ func CreateCluster() error {
 		c.ConfPath = confPath
 	}
 
-	gCmds, err := c.InitGCloudCmdSet()
-	if err != nil {
-		log.Fatal(err)
-	}
+	c.GetStorageOpts()
 
-	for _, cmd := range gCmds.Cmds {
-		if !cmd.Internal {
-			cmd.Execute(context.Background(), c)
-			if !cmd.Succeed {
-				// fmt.Println(cmd.stderr)
-				continue
-			}
+	if !o.DryRun {
+		var unlock func()
+		ctx, unlock, err = lockCluster(ctx, c.Name, "create")
+		if err != nil {
+			return err
 		}
-	}
+		defer unlock()
 
-	kCmds, err := c.InitKubeCmdSet()
-	if err != nil {
-		log.Fatal(err)
+		store, err := config.Store()
+		if err != nil {
+			return err
+		}
+		c.UseStore(store)
+
+		c.Journal = cluster.NewJournal(c.ConfPath)
+		err = c.GenerateConfig()
+		if errors.Is(err, config.ErrConflict) {
+			return fmt.Errorf("cluster \"%s\" already exists, run 'kmanager create --resume %s' to continue its creation or delete it first", c.Name, c.Name)
+		} else if err != nil {
+			return err
+		}
 	}
 
-	for _, cmd := range kCmds.Cmds {
-		if !cmd.Internal {
-			cmd.Execute(context.Background(), c)
-			if !cmd.Succeed {
-				fmt.Println(cmd.Stderr)
-				continue
+	return createCluster(ctx, c, o, plan)
+}
+
+// newCluster returns the cluster described by the spec file o.File, or asks
+// the user for its name, domain and node shape when there is none. Node
+// shape flags take precedence over both.
+func newCluster(o CreateOptions) (*cluster.Cluster, error) {
+	var c *cluster.Cluster
+	if o.File != "" {
+		spec, err := cluster.LoadSpec(o.File)
+		if err != nil {
+			return nil, err
+		}
+		c = spec.Cluster()
+	} else {
+		c = &cluster.Cluster{Nodes: cluster.DefaultNodeConfig()}
+
+		if err := survey.Ask(questions.ClusterName, &c.Name); err != nil {
+			return nil, err
+		}
+
+		if err := survey.Ask(questions.DomainName, &c.DNSName); err != nil {
+			return nil, err
+		}
+
+		if o.LocationMode == "" {
+			err := survey.Ask(questions.LocationModePrompt(cluster.LocationModes), &c.LocationMode)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !o.nodesFromFlags() {
+			err := askNodeShape(&c.Nodes)
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	err = cluster.CreateServiceAccount(c.GetServiceAccountOpts().DNSName)
+	if o.LocationMode != "" {
+		if o.LocationMode != cluster.LocationZonal && o.LocationMode != cluster.LocationRegional {
+			return nil, fmt.Errorf("invalid location mode %q, expected %s or %s", o.LocationMode, cluster.LocationZonal, cluster.LocationRegional)
+		}
+		c.LocationMode = o.LocationMode
+	}
+	if len(o.NodeLocations) > 0 {
+		c.NodeLocations = o.NodeLocations
+	}
+	if o.WorkloadIdentity {
+		c.WorkloadIdentity = true
+	}
+	if len(o.Labels) > 0 {
+		if c.Labels == nil {
+			c.Labels = map[string]string{}
+		}
+		for k, v := range o.Labels {
+			c.Labels[k] = v
+		}
+	}
+	err := cluster.ValidateLabels(c.Labels)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
-	err = cluster.BindServiceAccountToRole(c.GcloudProjectName, c.GetServiceAccountOpts().DNS, "roles/dns.admin")
+	o.applyNodeFlags(&c.Nodes)
+	err = c.Nodes.Validate()
 	if err != nil {
-		fmt.Println(err)
+		return nil, fmt.Errorf("invalid node shape: %w", err)
 	}
 
-	err = cluster.CreateServiceAccount(c.GetServiceAccountOpts().StorageName)
+	return c, nil
+}
+
+func askNodeShape(n *cluster.NodeConfig) error {
+	var customize bool
+	if err := survey.Ask(questions.NodeShapeConfirmation, &customize); err != nil {
+		return err
+	}
+	if !customize {
+		return nil
+	}
+
+	return survey.Ask(questions.NodeShapePrompt(n.MachineType, n.NumNodes, n.DiskSizeGB, n.DiskType, n.ImageType, n.Preemptible), n)
+}
+
+// resumeCluster reloads the cluster named o.Resume and continues its
+// creation from the first step missing from its journal. Its values were
+// settled by the first run, so the rest runs without prompting.
+func resumeCluster(ctx context.Context, e cluster.Executor, o CreateOptions) error {
+	if o.DryRun {
+		return errors.New("--dry-run can not be combined with --resume")
+	}
+
+	ctx, unlock, err := lockCluster(ctx, o.Resume, "create --resume")
 	if err != nil {
-		fmt.Println("error:", err)
+		return err
 	}
+	defer unlock()
 
-	err = cluster.BindServiceAccToBucket(c.GetServiceAccountOpts().Storage, c.GetStorageOpts().SourceCodeBucket, "objectCreator")
+	c, err := cluster.Get(o.Resume)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("No config file found of cluster named \"%s\", nothing to resume", o.Resume)
+	} else if err != nil {
+		return err
+	}
+	c.Exec = e
+	c.NonInteractive = true
+
+	c.Journal, err = cluster.LoadJournal(c.ConfPath)
 	if err != nil {
-		fmt.Println("error:", err)
+		return err
 	}
 
-	err = cluster.BindServiceAccToBucket(c.GetServiceAccountOpts().Storage, c.GetStorageOpts().CloudBuildBucket, "objectViewer")
+	return createCluster(ctx, &c, o, nil)
+}
+
+// createCluster runs every creation step for c which is not yet recorded as
+// done in its journal.
+func createCluster(ctx context.Context, c *cluster.Cluster, o CreateOptions, plan *cluster.Plan) error {
+	gCmds, err := c.InitGCloudCmdSet()
 	if err != nil {
-		fmt.Println("error:", err)
+		log.Fatal(err)
 	}
 
-	err = cluster.CreateServiceAccount(c.GetServiceAccountOpts().CloudBuildName)
+	kCmds, err := c.InitKubeCmdSet()
 	if err != nil {
-		fmt.Println("error:", err)
+		log.Fatal(err)
 	}
 
-	err = cluster.BindServiceAccountToRole(c.GcloudProjectName, c.GetServiceAccountOpts().CloudBuild, "roles/cloudbuild.builds.editor")
+	iamCmds, err := c.InitIAMCmdSet()
 	if err != nil {
-		fmt.Println("error:", err)
+		log.Fatal(err)
+	}
+
+	createCmds := cluster.NewCmdSet(c, "create")
+	createCmds.Concurrency = o.Parallelism
+	createCmds.StepTimeout = o.StepTimeout
+	createCmds.Adopt = o.Resume != ""
+	if o.DryRun {
+		// keep the plan in a stable order so two plans can be diffed
+		createCmds.Concurrency = 1
+	}
+	for _, cs := range []*cluster.CmdSet{gCmds, kCmds, iamCmds} {
+		err = createCmds.AddCmdSet(cs)
+		if err != nil {
+			return err
+		}
+	}
+
+	runErr := createCmds.Run(ctx)
+	if ctx.Err() != nil {
+		return interrupted(c, ctx.Err())
 	}
 
-	err = cluster.GenerateServiceAccountKey(c.GetServiceAccountOpts().CloudBuild, filepath.Join(c.ConfPath, c.GetServiceAccountOpts().CloudBuildName+".json"))
+	// the kubeapps need every resource, so they are not installed on a
+	// partially created cluster
+	if runErr != nil {
+		if o.RollbackOnFailure && !o.DryRun {
+			return rollbackOnFailure(ctx, *c, runErr)
+		}
+		return creationFailed(c, o, runErr)
+	}
+
+	if !o.DryRun {
+		err = c.ConfirmDNSServers(ctx)
+		if ctx.Err() != nil {
+			return interrupted(c, ctx.Err())
+		}
+		if err != nil {
+			return creationFailed(c, o, fmt.Errorf("listing the name servers: %w", err))
+		}
+	}
+
+	err = c.ConfigKubernetes(ctx)
+	if ctx.Err() != nil {
+		return interrupted(c, ctx.Err())
+	}
 	if err != nil {
-		fmt.Println("error:", err)
+		if o.RollbackOnFailure && !o.DryRun {
+			return rollbackOnFailure(ctx, *c, err)
+		}
+		return creationFailed(c, o, fmt.Errorf("configuring kubernetes: %w", err))
+	}
+
+	if o.DryRun {
+		return printPlan(plan, o.PlanFile)
 	}
 
-	err = cluster.GenerateServiceAccountKey(c.GetServiceAccountOpts().Storage, filepath.Join(c.ConfPath, c.GetServiceAccountOpts().StorageName+".json"))
+	err = c.GenerateConfig()
 	if err != nil {
-		fmt.Println("error:", err)
+		return err
+	}
+
+	return nil
+}
+
+// interrupted tells the user which steps were cut short, as the state of
+// their resources is unknown, and how to continue.
+func interrupted(c *cluster.Cluster, cause error) error {
+	if c.Journal == nil {
+		return cause
+	}
+
+	steps := c.Journal.Interrupted()
+	if len(steps) > 0 {
+		color.HiYellow("The following steps were in flight and may have partially created resources:")
+		for _, step := range steps {
+			fmt.Println("  -", step)
+		}
+	}
+	return fmt.Errorf("creation of cluster \"%s\" was interrupted (%w), run 'kmanager create --resume %s' to continue", c.Name, cause, c.Name)
+}
+
+// creationFailed tells the user how to continue a creation which failed
+// with cause.
+func creationFailed(c *cluster.Cluster, o CreateOptions, cause error) error {
+	if o.DryRun {
+		return cause
 	}
+	return fmt.Errorf("creation of cluster \"%s\" failed: %w\nfix the cause and run 'kmanager create --resume %s' to continue, or 'kmanager delete %s' to remove it", c.Name, cause, c.Name, c.Name)
+}
 
-	err = cluster.GenerateServiceAccountKey(c.GetServiceAccountOpts().DNS, filepath.Join(c.ConfPath, c.GetServiceAccountOpts().DNSName+".json"))
+func rollbackOnFailure(ctx context.Context, c cluster.Cluster, cause error) error {
+	err := rollbackCluster(ctx, c)
 	if err != nil {
-		fmt.Println("error:", err)
+		return fmt.Errorf("%v; %w", cause, err)
 	}
+	return fmt.Errorf("cluster creation failed and was rolled back: %w", cause)
+}
 
-	err = c.ConfigKubernetes()
+func printPlan(plan *cluster.Plan, planFile string) error {
+	err := plan.WriteText(os.Stdout)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 
-	err = c.GenerateConfig()
+	if planFile == "" {
+		return nil
+	}
+
+	f, err := os.Create(planFile)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	return nil
+	return plan.WriteJSON(f)
 }
 
 func init() {
-	rootCmd.AddCommand(createCmd)
+	rootCmd.AddCommand(newCreateCmd())
 }
@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/urvil38/kmanager/cluster"
+	"github.com/urvil38/kmanager/config"
+)
+
+// testHome points the kmanager config dir and the state store at a fresh
+// temporary dir for the duration of the test.
+func testHome(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kmanager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home, store := config.Home, config.StateStoreURI
+	config.Home, config.StateStoreURI = dir, ""
+	t.Cleanup(func() {
+		config.Home, config.StateStoreURI = home, store
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// kubeappIndex serves an index of the named kubeapps, each a plain
+// manifest, and counts how often the index is fetched.
+func kubeappIndex(t *testing.T, apps ...string) (string, *int32) {
+	var hits int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".yaml"); name != "" {
+			fmt.Fprintf(w, "kind: ConfigMap\nmetadata:\n  name: %s\n", name)
+			return
+		}
+
+		atomic.AddInt32(&hits, 1)
+		fmt.Fprint(w, "apiVersion: v1\nkind: KubeApps\napps:\n")
+		if len(apps) == 0 {
+			fmt.Fprint(w, "  []\n")
+		}
+		for _, name := range apps {
+			fmt.Fprintf(w, "- name: %s\n  path: %s/%s.yaml\n", name, srv.URL, name)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &hits
+}
+
+// writeSpec writes the spec of the zonal cluster demo in project proj. It
+// uses Workload Identity, as the fake gcloud generates no keys.
+func writeSpec(t *testing.T, dir, index string) string {
+	spec := fmt.Sprintf(`apiVersion: kmanager/v1alpha1
+kind: Cluster
+metadata:
+  name: demo
+spec:
+  dnsName: demo.example.com
+  project: proj
+  region: us-central1
+  zone: us-central1-a
+  workloadIdentity: true
+  kubeapps:
+    index: %s
+`, index)
+	path := filepath.Join(dir, "demo.yaml")
+	err := ioutil.WriteFile(path, []byte(spec), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// createResponses scripts the calls of a successful creation of demo,
+// preceded by the given responses which take precedence.
+func createResponses(first ...cluster.FakeResponse) []cluster.FakeResponse {
+	return append(first,
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"config", "list", "--format", "json"}, Stdout: `{"core":{"account":"me@example.com"}}`},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"projects", "list", "--filter", "lifecycleState:ACTIVE", "--format", "json"}, Stdout: `[{"name":"Proj","projectId":"proj"}]`},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"dns", "record-sets", "list", "--zone", "demo", "--format", "json"}, Stdout: `[{"type":"NS","rrdatas":["ns1.example.com."]}]`},
+		cluster.FakeResponse{RootCmd: "gcloud"},
+		cluster.FakeResponse{RootCmd: "gsutil"},
+		cluster.FakeResponse{RootCmd: "kubectl"},
+	)
+}
+
+func TestCreateClusterFailedStep(t *testing.T) {
+	home := testHome(t)
+	index, hits := kubeappIndex(t)
+
+	f := cluster.NewFakeExecutor(createResponses(cluster.FakeResponse{
+		RootCmd:  "gcloud",
+		Args:     []string{"dns", "managed-zones", "create", "demo", "--dns-name", "demo.example.com", "--project", "proj", "--description", "kubepaas managed zone", "--labels", "kmanager-cluster=demo,managed-by=kmanager"},
+		ExitCode: 1,
+		Stderr:   "ERROR: (gcloud.dns.managed-zones.create) PERMISSION_DENIED: Forbidden",
+	})...)
+
+	err := CreateCluster(context.Background(), f, CreateOptions{File: writeSpec(t, home, index), Parallelism: 1})
+	if err == nil {
+		t.Fatal("CreateCluster succeeded with a failed step")
+	}
+	if !strings.Contains(err.Error(), "create-dns-zone") || !strings.Contains(err.Error(), "--resume demo") {
+		t.Errorf("CreateCluster = %v, want it to name the failed step and how to resume", err)
+	}
+	if n := atomic.LoadInt32(hits); n != 0 {
+		t.Errorf("kubeapp index fetched %d times on a failed create", n)
+	}
+}
+
+func TestCreateCluster(t *testing.T) {
+	home := testHome(t)
+	index, hits := kubeappIndex(t)
+	f := cluster.NewFakeExecutor(createResponses()...)
+
+	err := CreateCluster(context.Background(), f, CreateOptions{File: writeSpec(t, home, index), Parallelism: 2})
+	if err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+
+	for _, call := range [][]string{
+		{"gcloud", "container", "clusters", "create", "demo"},
+		{"gcloud", "dns", "managed-zones", "create", "demo"},
+		{"gsutil", "mb", "-l", "us-central1", "gs://demo-sourcecode"},
+		{"gsutil", "mb", "-l", "us-central1", "gs://demo-cloudbuild-logs"},
+		{"gcloud", "iam", "service-accounts", "create", "demo-cert-clouddns"},
+		{"gcloud", "iam", "service-accounts", "create", "demo-storage"},
+		{"gcloud", "iam", "service-accounts", "create", "demo-cloudbuild"},
+	} {
+		if n := f.Called(call[0], call[1:]...); n != 1 {
+			t.Errorf("%s run %d times, want once", strings.Join(call, " "), n)
+		}
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("kubeapp index fetched %d times, want once", n)
+	}
+
+	c, err := cluster.Get("demo")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if c.GcloudProjectName != "proj" || c.Zone != "us-central1-a" || c.Account != "me@example.com" {
+		t.Errorf("stored cluster = %+v", c)
+	}
+
+	j, err := cluster.LoadJournal(c.ConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Done("create-kubernetes-cluster") || !j.Done("bind-cloudbuild-service-account-workload-identity") {
+		t.Errorf("journal misses steps: %+v", j.Entries)
+	}
+
+	// the name servers are confirmed once every step run in parallel is done
+	listed := -1
+	for i, p := range f.Calls {
+		if p.Name == "list-dns-server" {
+			listed = i
+		}
+	}
+	if listed < 0 {
+		t.Fatal("name servers never listed")
+	}
+	for i, p := range f.Calls[listed+1:] {
+		first := true
+		for _, q := range f.Calls[:listed+1+i] {
+			first = first && q.Name != p.Name
+		}
+		if first && j.Done(p.Name) {
+			t.Errorf("step %s run after the name servers were listed", p.Name)
+		}
+	}
+}
+
+func TestCreateClusterExisting(t *testing.T) {
+	home := testHome(t)
+	index, _ := kubeappIndex(t)
+	spec := writeSpec(t, home, index)
+
+	err := CreateCluster(context.Background(), cluster.NewFakeExecutor(createResponses()...), CreateOptions{File: spec})
+	if err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+
+	f := cluster.NewFakeExecutor(createResponses()...)
+	err = CreateCluster(context.Background(), f, CreateOptions{File: spec})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("second CreateCluster = %v, want already exists", err)
+	}
+	if len(f.Calls) != 0 {
+		t.Errorf("second CreateCluster ran %d commands", len(f.Calls))
+	}
+}
+
+func TestCreateClusterResume(t *testing.T) {
+	home := testHome(t)
+	index, hits := kubeappIndex(t)
+	spec := writeSpec(t, home, index)
+
+	failing := cluster.NewFakeExecutor(createResponses(cluster.FakeResponse{
+		RootCmd:  "gcloud",
+		Args:     []string{"dns", "managed-zones", "create", "demo", "--dns-name", "demo.example.com", "--project", "proj", "--description", "kubepaas managed zone", "--labels", "kmanager-cluster=demo,managed-by=kmanager"},
+		ExitCode: 1,
+		Stderr:   "ERROR: (gcloud.dns.managed-zones.create) PERMISSION_DENIED: Forbidden",
+	})...)
+	if err := CreateCluster(context.Background(), failing, CreateOptions{File: spec, Parallelism: 1}); err == nil {
+		t.Fatal("CreateCluster succeeded with a failed step")
+	}
+
+	f := cluster.NewFakeExecutor(createResponses()...)
+	err := CreateCluster(context.Background(), f, CreateOptions{Resume: "demo", Parallelism: 1})
+	if err != nil {
+		t.Fatalf("resumed CreateCluster: %v", err)
+	}
+
+	if n := f.Called("gcloud", "container", "clusters", "create", "demo"); n != 0 {
+		t.Errorf("resumed create recreated the kubernetes cluster %d times", n)
+	}
+	if n := f.Called("gcloud", "dns", "managed-zones", "create", "demo"); n != 1 {
+		t.Errorf("resumed create ran the failed step %d times, want once", n)
+	}
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("kubeapp index fetched %d times, want once", n)
+	}
+
+	if err := CreateCluster(context.Background(), f, CreateOptions{Resume: "demo", DryRun: true}); err == nil {
+		t.Error("--resume combined with --dry-run succeeded")
+	}
+}
+
+func TestCreateClusterKubeServiceAccountFailure(t *testing.T) {
+	home := testHome(t)
+	index, _ := kubeappIndex(t, "cluster-issuer")
+
+	f := cluster.NewFakeExecutor(createResponses(cluster.FakeResponse{
+		RootCmd:  "kubectl",
+		Args:     []string{"create", "serviceaccount", "cert-manager", "--namespace", "cert-manager"},
+		ExitCode: 1,
+		Stderr:   `Error from server (Forbidden): serviceaccounts is forbidden`,
+	})...)
+
+	err := CreateCluster(context.Background(), f, CreateOptions{File: writeSpec(t, home, index), Parallelism: 2})
+	if err == nil || !strings.Contains(err.Error(), "create-kubernetes-service-account") {
+		t.Fatalf("CreateCluster = %v, want the failed service account creation", err)
+	}
+	if n := f.Called("kubectl", "create", "-f", "-"); n != 0 {
+		t.Errorf("cluster-issuer applied %d times without its service account", n)
+	}
+}
+
+// resource pairs the call creating a resource with the one deleting it.
+type resource struct {
+	create []string
+	delete []string
+}
+
+var createdResources = []resource{
+	{[]string{"gcloud", "container", "clusters", "create", "demo"}, []string{"gcloud", "container", "clusters", "delete", "demo"}},
+	{[]string{"gcloud", "dns", "managed-zones", "create", "demo"}, []string{"gcloud", "dns", "managed-zones", "delete", "demo"}},
+	{[]string{"gsutil", "mb", "-l", "us-central1", "gs://demo-sourcecode"}, []string{"gsutil", "-m", "rm", "-r", "gs://demo-sourcecode"}},
+	{[]string{"gsutil", "mb", "-l", "us-central1", "gs://demo-cloudbuild-logs"}, []string{"gsutil", "-m", "rm", "-r", "gs://demo-cloudbuild-logs"}},
+	{[]string{"gcloud", "iam", "service-accounts", "create", "demo-cert-clouddns"}, []string{"gcloud", "iam", "service-accounts", "delete", "demo-cert-clouddns@proj.iam.gserviceaccount.com"}},
+	{[]string{"gcloud", "iam", "service-accounts", "create", "demo-storage"}, []string{"gcloud", "iam", "service-accounts", "delete", "demo-storage@proj.iam.gserviceaccount.com"}},
+	{[]string{"gcloud", "iam", "service-accounts", "create", "demo-cloudbuild"}, []string{"gcloud", "iam", "service-accounts", "delete", "demo-cloudbuild@proj.iam.gserviceaccount.com"}},
+}
+
+// callIndex returns the index of the first call of f matching argv, or -1.
+func callIndex(f *cluster.FakeExecutor, argv []string) int {
+	for i, p := range f.Calls {
+		if p.RootCmd != argv[0] || len(p.Args) < len(argv)-1 {
+			continue
+		}
+		match := true
+		for j, arg := range argv[1:] {
+			match = match && p.Args[j] == arg
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestCreateClusterRollbackOnFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		apps     []string
+		fail     cluster.FakeResponse
+		rollback bool
+		failed   string
+	}{
+		{
+			name:     "failed step",
+			fail:     cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "create", "demo-storage", "--display-name", "demo-storage", "--description", "kmanager-cluster=demo,managed-by=kmanager"}, ExitCode: 1, Stderr: "ERROR: PERMISSION_DENIED: denied"},
+			rollback: true,
+			failed:   "demo-storage",
+		},
+		{
+			name:     "failed kubeapp",
+			apps:     []string{"nginx"},
+			fail:     cluster.FakeResponse{RootCmd: "kubectl", Args: []string{"create", "-f", "-"}, ExitCode: 1, Stderr: "error: unable to recognize"},
+			rollback: true,
+		},
+		{
+			name: "failed kubeapp without rollback",
+			apps: []string{"nginx"},
+			fail: cluster.FakeResponse{RootCmd: "kubectl", Args: []string{"create", "-f", "-"}, ExitCode: 1, Stderr: "error: unable to recognize"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := testHome(t)
+			index, _ := kubeappIndex(t, tt.apps...)
+			f := cluster.NewFakeExecutor(createResponses(tt.fail)...)
+
+			err := CreateCluster(context.Background(), f, CreateOptions{File: writeSpec(t, home, index), Parallelism: 1, RollbackOnFailure: tt.rollback})
+			if err == nil {
+				t.Fatal("CreateCluster succeeded")
+			}
+			if rolledBack := strings.Contains(err.Error(), "rolled back"); rolledBack != tt.rollback {
+				t.Errorf("CreateCluster = %v, want rolled back %v", err, tt.rollback)
+			}
+			if _, err := cluster.Get("demo"); errors.Is(err, os.ErrNotExist) != tt.rollback {
+				t.Errorf("config removed = %v, want %v", errors.Is(err, os.ErrNotExist), tt.rollback)
+			}
+
+			// with a single step at a time, calls are made in the order
+			// the steps complete
+			created, deleted := []int{}, []int{}
+			for _, r := range createdResources {
+				c, d := callIndex(f, r.create), callIndex(f, r.delete)
+				if strings.HasSuffix(r.create[len(r.create)-1], tt.failed) && tt.failed != "" {
+					if d >= 0 {
+						t.Errorf("%s undone though it was never created", strings.Join(r.create, " "))
+					}
+					continue
+				}
+				switch {
+				case c < 0 && d >= 0:
+					t.Errorf("%s undone though it was never created", strings.Join(r.create, " "))
+				case c >= 0 && (d >= 0) != tt.rollback:
+					t.Errorf("%s undone = %v, want %v", strings.Join(r.create, " "), d >= 0, tt.rollback)
+				case c >= 0 && d >= 0:
+					created = append(created, c)
+					deleted = append(deleted, d)
+				}
+			}
+
+			if tt.rollback && len(created) < 2 {
+				t.Fatalf("only %d resources were rolled back", len(created))
+			}
+			for i := range created {
+				for j := range created {
+					if created[i] < created[j] && deleted[i] < deleted[j] {
+						t.Errorf("deletes not in reverse order of creation: created at %v, deleted at %v", created, deleted)
+						return
+					}
+				}
+			}
+		})
+	}
+}
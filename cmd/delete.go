@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/urvil38/kmanager/cluster"
@@ -44,7 +46,7 @@ func newDeleteCmd() *cobra.Command {
 
 			if len(args) > 0 {
 				o.ClusterName = args[0]
-				err := deleteCluster(*o)
+				err := deleteCluster(cmd.Context(), cluster.DefaultExecutor, *o)
 				if err != nil {
 					cmd.PrintErrln("Oops, got error while deleting cluster:", err)
 					os.Exit(1)
@@ -69,61 +71,68 @@ func validate(args []string, usage string) error {
 	return nil
 }
 
-func deleteCluster(o DeleteOptions) error {
-	cc, err := cluster.Get(o.ClusterName)
-	if errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("No config file found of cluster named \"%s\"", o.ClusterName)
-	} else if err != nil {
+func deleteCluster(ctx context.Context, e cluster.Executor, o DeleteOptions) error {
+	ctx, unlock, err := lockCluster(ctx, o.ClusterName, "delete")
+	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	err = DeleteKubernetesCluster(cc)
+	c, err := getCluster(o.ClusterName, e)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
+	cc := *c
 
-	if !o.LeaveDNSZone {
-		err = DeleteDNSZone(cc)
-		if err != nil {
-			fmt.Println(err)
+	failed := 0
+	report := func(err error) {
+		if errors.Is(err, cluster.ErrNotFound) {
+			fmt.Println("already deleted, skipping:", strings.SplitN(err.Error(), "\n", 2)[0])
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, cluster.Explain(err))
+			failed++
 		}
 	}
 
-	err = DeleteStorageBuckets(cc)
-	if err != nil {
-		fmt.Println(err)
+	// node pools go away together with the cluster
+	for _, p := range cc.NodePools {
+		fmt.Printf("node pool \"%s\" will be deleted along with the cluster\n", p.Name)
 	}
+	report(DeleteKubernetesCluster(ctx, cc))
 
-	err = DeleteServiceAccounts(cc)
-	if err != nil {
-		fmt.Println(err)
+	if !o.LeaveDNSZone {
+		report(DeleteDNSZone(ctx, cc))
 	}
 
-	err = os.RemoveAll(cc.ConfPath)
-	if err != nil {
-		return err
+	report(DeleteStorageBuckets(ctx, cc))
+
+	report(DeleteServiceAccounts(ctx, cc))
+
+	if failed > 0 {
+		return fmt.Errorf("%d step(s) failed, keeping the config of cluster \"%s\" so delete can be retried", failed, cc.Name)
 	}
 
-	return nil
+	return cc.RemoveConfig()
 }
 
-func DeleteKubernetesCluster(c cluster.Cluster) error {
+func DeleteKubernetesCluster(ctx context.Context, c cluster.Cluster) error {
 	deleteKubernetesClusterCmd := cluster.Command{
 		Name:    "delete-kubernetes-cluster",
 		RootCmd: "gcloud",
-		Args: []string{
-			"container", "clusters", "delete", c.Name, "--quiet", "--zone", c.Zone, "--project", c.GcloudProjectName,
-		},
+		Timeout: 30 * time.Minute,
+		Args: append([]string{
+			"container", "clusters", "delete", c.Name, "--quiet", "--project", c.GcloudProjectName,
+		}, c.LocationArgs()...),
 	}
 
-	deleteKubernetesClusterCmd.Execute(context.Background(), nil)
+	deleteKubernetesClusterCmd.Execute(ctx, &c)
 	if !deleteKubernetesClusterCmd.Succeed {
 		return deleteKubernetesClusterCmd.Stderr
 	}
 	return nil
 }
 
-func DeleteDNSZone(c cluster.Cluster) error {
+func DeleteDNSZone(ctx context.Context, c cluster.Cluster) error {
 	deleteDNSZoneCmd := cluster.Command{
 		Name:    "delete-dns-zone",
 		RootCmd: "gcloud",
@@ -132,25 +141,25 @@ func DeleteDNSZone(c cluster.Cluster) error {
 		},
 	}
 
-	deleteDNSZoneCmd.Execute(context.Background(), nil)
+	deleteDNSZoneCmd.Execute(ctx, &c)
 	if !deleteDNSZoneCmd.Succeed {
 		return deleteDNSZoneCmd.Stderr
 	}
 	return nil
 }
 
-func DeleteStorageBuckets(c cluster.Cluster) error {
+func DeleteStorageBuckets(ctx context.Context, c cluster.Cluster) error {
 
 	if c.Storage.CloudBuildBucket != "" {
-		err := deleteBucket(c.Storage.CloudBuildBucket)
-		if err != nil {
+		err := deleteBucket(ctx, c.Exec, c.Storage.CloudBuildBucket)
+		if err != nil && !errors.Is(err, cluster.ErrNotFound) {
 			return err
 		}
 	}
 
 	if c.Storage.SourceCodeBucket != "" {
-		err := deleteBucket(c.Storage.SourceCodeBucket)
-		if err != nil {
+		err := deleteBucket(ctx, c.Exec, c.Storage.SourceCodeBucket)
+		if err != nil && !errors.Is(err, cluster.ErrNotFound) {
 			return err
 		}
 	}
@@ -158,24 +167,24 @@ func DeleteStorageBuckets(c cluster.Cluster) error {
 	return nil
 }
 
-func DeleteServiceAccounts(c cluster.Cluster) error {
+func DeleteServiceAccounts(ctx context.Context, c cluster.Cluster) error {
 	if c.ServiceAccount.CloudBuild != "" {
-		err := deleteServiceAccount(c.ServiceAccount.CloudBuild)
-		if err != nil {
+		err := deleteServiceAccount(ctx, c.Exec, c.ServiceAccount.CloudBuild)
+		if err != nil && !errors.Is(err, cluster.ErrNotFound) {
 			return err
 		}
 	}
 
 	if c.ServiceAccount.Storage != "" {
-		err := deleteServiceAccount(c.ServiceAccount.Storage)
-		if err != nil {
+		err := deleteServiceAccount(ctx, c.Exec, c.ServiceAccount.Storage)
+		if err != nil && !errors.Is(err, cluster.ErrNotFound) {
 			return err
 		}
 	}
 
 	if c.ServiceAccount.DNS != "" {
-		err := deleteServiceAccount(c.ServiceAccount.DNS)
-		if err != nil {
+		err := deleteServiceAccount(ctx, c.Exec, c.ServiceAccount.DNS)
+		if err != nil && !errors.Is(err, cluster.ErrNotFound) {
 			return err
 		}
 	}
@@ -183,16 +192,17 @@ func DeleteServiceAccounts(c cluster.Cluster) error {
 	return nil
 }
 
-func deleteBucket(name string) error {
+func deleteBucket(ctx context.Context, e cluster.Executor, name string) error {
 	deleteBucketCmd := cluster.Command{
-		Name:    "delete-storage-bucket",
-		RootCmd: "gsutil",
+		Name:     "delete-storage-bucket",
+		RootCmd:  "gsutil",
+		Executor: e,
 		Args: []string{
 			"-m", "rm", "-r", fmt.Sprintf(cluster.StorageBucketFmt, name),
 		},
 	}
 
-	deleteBucketCmd.Execute(context.Background(), nil)
+	deleteBucketCmd.Execute(ctx, nil)
 	if !deleteBucketCmd.Succeed {
 		return deleteBucketCmd.Stderr
 	}
@@ -200,17 +210,18 @@ func deleteBucket(name string) error {
 	return nil
 }
 
-func deleteServiceAccount(name string) error {
+func deleteServiceAccount(ctx context.Context, e cluster.Executor, name string) error {
 	deleteServiceAccountCmd := cluster.Command{
-		Name:    "delete-service-account",
-		RootCmd: "gcloud",
+		Name:     "delete-service-account",
+		RootCmd:  "gcloud",
+		Executor: e,
 		Args: []string{
 			"iam", "service-accounts",
 			"delete", name,
 		},
 	}
 
-	deleteServiceAccountCmd.Execute(context.Background(), nil)
+	deleteServiceAccountCmd.Execute(ctx, nil)
 	if !deleteServiceAccountCmd.Succeed {
 		return deleteServiceAccountCmd.Stderr
 	}
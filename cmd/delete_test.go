@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/urvil38/kmanager/cluster"
+)
+
+// storeCluster records the cluster demo as created, with the given
+// kubeapps installed.
+func storeCluster(t *testing.T, apps ...string) {
+	home := testHome(t)
+	index, _ := kubeappIndex(t, apps...)
+	err := CreateCluster(context.Background(), cluster.NewFakeExecutor(createResponses()...), CreateOptions{File: writeSpec(t, home, index)})
+	if err != nil {
+		t.Fatalf("CreateCluster: %v", err)
+	}
+}
+
+func TestDeleteCluster(t *testing.T) {
+	notFound := cluster.FakeResponse{RootCmd: "gsutil", Args: []string{"-m", "rm", "-r", "gs://demo-sourcecode"}, ExitCode: 1, Stderr: "BucketNotFoundException: 404 gs://demo-sourcecode bucket does not exist."}
+	failed := cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"dns", "managed-zones", "delete", "demo"}, ExitCode: 1, Stderr: "ERROR: (gcloud.dns.managed-zones.delete) PERMISSION_DENIED: Forbidden"}
+
+	tests := []struct {
+		name        string
+		responses   []cluster.FakeResponse
+		opts        DeleteOptions
+		wantErr     bool
+		wantDNSCall int
+	}{
+		{"all deleted", nil, DeleteOptions{}, false, 1},
+		{"already deleted bucket", []cluster.FakeResponse{notFound}, DeleteOptions{}, false, 1},
+		{"leave dns zone", []cluster.FakeResponse{failed}, DeleteOptions{LeaveDNSZone: true}, false, 0},
+		{"failed step keeps config", []cluster.FakeResponse{failed}, DeleteOptions{}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeCluster(t)
+
+			f := cluster.NewFakeExecutor(append(tt.responses, cluster.FakeResponse{RootCmd: "gcloud"}, cluster.FakeResponse{RootCmd: "gsutil"})...)
+			o := tt.opts
+			o.ClusterName = "demo"
+			err := deleteCluster(context.Background(), f, o)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deleteCluster = %v, want error %v", err, tt.wantErr)
+			}
+
+			for _, call := range [][]string{
+				{"gcloud", "container", "clusters", "delete", "demo", "--quiet", "--project", "proj", "--zone", "us-central1-a"},
+				{"gsutil", "-m", "rm", "-r", "gs://demo-sourcecode"},
+				{"gsutil", "-m", "rm", "-r", "gs://demo-cloudbuild-logs"},
+				{"gcloud", "iam", "service-accounts", "delete", "demo-cert-clouddns@proj.iam.gserviceaccount.com"},
+				{"gcloud", "iam", "service-accounts", "delete", "demo-storage@proj.iam.gserviceaccount.com"},
+				{"gcloud", "iam", "service-accounts", "delete", "demo-cloudbuild@proj.iam.gserviceaccount.com"},
+			} {
+				if n := f.Called(call[0], call[1:]...); n != 1 {
+					t.Errorf("%s run %d times, want once", strings.Join(call, " "), n)
+				}
+			}
+			if n := f.Called("gcloud", "dns", "managed-zones", "delete", "demo"); n != tt.wantDNSCall {
+				t.Errorf("dns zone deleted %d times, want %d", n, tt.wantDNSCall)
+			}
+
+			_, err = cluster.Get("demo")
+			if tt.wantErr && err != nil {
+				t.Errorf("config of a partially deleted cluster is gone: %v", err)
+			}
+			if !tt.wantErr && !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("config of the deleted cluster is left: %v", err)
+			}
+		})
+	}
+}
@@ -3,9 +3,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 
 	"github.com/spf13/cobra"
 	"github.com/urvil38/kmanager/config"
@@ -32,20 +30,18 @@ var describeCmd = &cobra.Command{
 		}
 
 		name := args[0]
-		path, err := config.ClusterPath(name)
+		store, err := config.Store()
 		if err != nil {
 			cmd.Println(err)
 			os.Exit(1)
 		}
 
-		if _, err := os.Stat(filepath.Join(path, "config.json")); errors.Is(err, os.ErrNotExist) {
-			cmd.Println("Unable to print configuration, config.json file not exists")
+		b, _, err := store.Get(cmd.Context(), name)
+		if errors.Is(err, config.ErrStateNotFound) {
+			cmd.Printf("No cluster found with name \"%s\"\n", name)
 			os.Exit(1)
-		}
-
-		b, err := ioutil.ReadFile(filepath.Join(path, "config.json"))
-		if err != nil {
-			cmd.Printf("Unable to print configuration, %v", err)
+		} else if err != nil {
+			cmd.Printf("Unable to print configuration, %v\n", err)
 			os.Exit(1)
 		}
 
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/cluster"
+)
+
+const (
+	exportUsageStr = "export [cluster name]"
+)
+
+var (
+	exportUsageErrStr = fmt.Sprintf("expected '%s'.\ncluster name is a required argument for the export command", exportUsageStr)
+)
+
+type ExportOptions struct {
+	ClusterName string
+	Output      string
+	IncludeKeys bool
+}
+
+func newExportOptions() *ExportOptions {
+	return &ExportOptions{}
+}
+
+// newExportCmd represents the export command
+func newExportCmd() *cobra.Command {
+	o := newExportOptions()
+
+	cmd := &cobra.Command{
+		Use:   exportUsageStr,
+		Short: "Package the config of a cluster into a bundle to hand it to another machine",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := validate(args, exportUsageErrStr)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+
+			o.ClusterName = args[0]
+			if o.Output == "" {
+				o.Output = o.ClusterName + ".tgz"
+			}
+
+			err = exportCluster(*o)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while exporting cluster:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *ExportOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.Output, "output", "o", "", "path of the bundle, defaults to <cluster name>.tgz")
+	cmd.Flags().BoolVar(&o.IncludeKeys, "include-keys", false, "include the service account keys, encrypted with the passphrase")
+}
+
+func exportCluster(o ExportOptions) error {
+	c, err := getCluster(o.ClusterName, cluster.DefaultExecutor)
+	if err != nil {
+		return err
+	}
+
+	// write next to the bundle and rename, so a failed export leaves no
+	// truncated bundle behind
+	tmp, err := ioutil.TempFile(filepath.Dir(o.Output), "."+filepath.Base(o.Output)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	m, err := c.ExportBundle(tmp, o.IncludeKeys)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), o.Output)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range m.Files {
+		fmt.Printf("%8d  %s\n", f.Size, f.Name)
+	}
+	color.HiGreen("exported cluster \"%s\" to %s", c.Name, o.Output)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newExportCmd())
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/cluster"
+	"github.com/urvil38/kmanager/config"
+)
+
+const (
+	importUsageStr = "import [gke cluster name]"
+)
+
+var (
+	importUsageErrStr = fmt.Sprintf("expected '%s'.\ngke cluster name is a required argument for the import command", importUsageStr)
+)
+
+type ImportOptions struct {
+	cluster.ImportOptions
+}
+
+func newImportOptions() *ImportOptions {
+	return &ImportOptions{}
+}
+
+// newImportCmd represents the import command
+func newImportCmd() *cobra.Command {
+	o := newImportOptions()
+
+	cmd := &cobra.Command{
+		Use:   importUsageStr,
+		Short: "Manage an existing GKE cluster with kmanager by rebuilding its config",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := validate(args, importUsageErrStr)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+
+			o.Name = args[0]
+			err = importCluster(cmd.Context(), cluster.DefaultExecutor, *o)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while importing cluster:", cluster.Explain(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *ImportOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.Project, "project", "", "gcloud project of the cluster, defaults to the active one")
+	cmd.Flags().StringVar(&o.Account, "account", "", "gcloud account managing the cluster, defaults to the active one")
+	cmd.Flags().StringVar(&o.Location, "location", "", "zone or region of the cluster, needed when several clusters share its name")
+	cmd.Flags().StringVar(&o.DNSName, "dns-name", "", "domain name of the cluster, defaults to the one of its DNS zone")
+}
+
+func importCluster(ctx context.Context, e cluster.Executor, o ImportOptions) error {
+	ctx, unlock, err := lockCluster(ctx, o.Name, "import")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = cluster.Get(o.Name)
+	if err == nil {
+		return fmt.Errorf("cluster \"%s\" is managed by kmanager already, see 'kmanager describe %s'", o.Name, o.Name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	c, report, err := cluster.Import(ctx, e, o.ImportOptions)
+	if err != nil {
+		return err
+	}
+
+	c.ConfPath, err = config.CreateConfigDir(c.Name)
+	if err != nil {
+		return err
+	}
+
+	store, err := config.Store()
+	if err != nil {
+		return err
+	}
+	c.UseStore(store)
+
+	err = c.GenerateConfig()
+	if errors.Is(err, config.ErrConflict) {
+		return fmt.Errorf("cluster \"%s\" was imported concurrently", c.Name)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	for _, f := range report.Found {
+		color.HiGreen("found      %s", f)
+	}
+	for _, m := range report.Missing {
+		color.HiYellow("not found  %s", m)
+	}
+	fmt.Printf("\nimported cluster \"%s\", run 'kmanager status %s' to check it\n", c.Name, c.Name)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newImportCmd())
+}
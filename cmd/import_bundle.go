@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/cluster"
+	"github.com/urvil38/kmanager/config"
+)
+
+const (
+	importBundleUsageStr = "import-bundle [bundle]"
+)
+
+var (
+	importBundleUsageErrStr = fmt.Sprintf("expected '%s'.\nbundle is a required argument for the import-bundle command", importBundleUsageStr)
+)
+
+type ImportBundleOptions struct {
+	Path  string
+	Force bool
+}
+
+func newImportBundleOptions() *ImportBundleOptions {
+	return &ImportBundleOptions{}
+}
+
+// newImportBundleCmd represents the import-bundle command
+func newImportBundleCmd() *cobra.Command {
+	o := newImportBundleOptions()
+
+	cmd := &cobra.Command{
+		Use:   importBundleUsageStr,
+		Short: "Take over a cluster from a bundle made by 'kmanager export'",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := validate(args, importBundleUsageErrStr)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+
+			o.Path = args[0]
+			err = importBundle(cmd.Context(), *o)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while importing bundle:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *ImportBundleOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.Force, "force", false, "overwrite the config of an existing cluster of the same name")
+}
+
+func importBundle(ctx context.Context, o ImportBundleOptions) error {
+	f, err := os.Open(o.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := cluster.ReadBundle(f)
+	if err != nil {
+		return err
+	}
+
+	c, err := b.Cluster()
+	if err != nil {
+		return err
+	}
+
+	_, unlock, err := lockCluster(ctx, c.Name, "import-bundle")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = cluster.Get(c.Name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if exists && !o.Force {
+		return fmt.Errorf("cluster \"%s\" exists already, pass --force to overwrite it", c.Name)
+	}
+
+	if !exists {
+		store, err := config.Store()
+		if err != nil {
+			return err
+		}
+		c.UseStore(store)
+	}
+
+	err = installBundle(b, c)
+	if errors.Is(err, config.ErrConflict) {
+		return fmt.Errorf("cluster \"%s\" was created concurrently, pass --force to overwrite it", c.Name)
+	} else if err != nil {
+		return err
+	}
+
+	for _, f := range b.Manifest.Files {
+		if strings.HasSuffix(f.Name, config.EncryptedExt) {
+			fmt.Printf("the service account keys are encrypted, export %s or %s with the passphrase of the exporting machine to use them\n", config.PassphraseEnv, config.KeyFileEnv)
+			break
+		}
+	}
+	color.HiGreen("imported cluster \"%s\" from %s", c.Name, o.Path)
+	return nil
+}
+
+// installBundle writes the files of b into a temporary dir next to the
+// config dir of c, and only once that succeeded moves it into place along
+// with the config. A dir left by an existing cluster is kept until then,
+// and restored when anything fails.
+func installBundle(b *cluster.Bundle, c *cluster.Cluster) error {
+	home, err := config.KmanagerConfigPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(home, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(home, "."+c.Name+".import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = b.Install(tmp)
+	if err != nil {
+		return err
+	}
+
+	dir, err := config.ClusterDir(c.Name)
+	if err != nil {
+		return err
+	}
+	backup := tmp + ".old"
+	err = os.Rename(dir, backup)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	restore := func() {
+		os.RemoveAll(dir)
+		os.Rename(backup, dir)
+	}
+
+	err = os.Rename(tmp, dir)
+	if err != nil {
+		restore()
+		return err
+	}
+
+	c.ConfPath = dir
+	err = c.GenerateConfig()
+	if err != nil {
+		restore()
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+func init() {
+	rootCmd.AddCommand(newImportBundleCmd())
+}
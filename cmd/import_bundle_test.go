@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urvil38/kmanager/cluster"
+	"github.com/urvil38/kmanager/config"
+)
+
+// exportDemo stores the cluster demo and exports it into a bundle outside
+// of the config dir.
+func exportDemo(t *testing.T) string {
+	storeCluster(t)
+	c, err := cluster.Get("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.TempDir("", "kmanager-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(out) })
+
+	path := filepath.Join(out, "demo.tgz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = c.ExportBundle(f, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportBundle(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  bool
+		force     bool
+		wantErr   bool
+		wantStale bool
+	}{
+		{name: "new cluster"},
+		{name: "existing cluster", existing: true, wantErr: true, wantStale: true},
+		{name: "forced over existing cluster", existing: true, force: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := exportDemo(t)
+			home := config.Home
+			if !tt.existing {
+				home = testHome(t)
+			}
+			dir := filepath.Join(home, "demo")
+			stale := filepath.Join(dir, "stale")
+			if tt.existing {
+				err := ioutil.WriteFile(stale, []byte("left by the existing cluster"), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := importBundle(context.Background(), ImportBundleOptions{Path: bundle, Force: tt.force})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("importBundle = %v, want error %v", err, tt.wantErr)
+			}
+
+			if _, err := os.Stat(stale); (err == nil) != tt.wantStale {
+				t.Errorf("stale file kept = %v, want %v", err == nil, tt.wantStale)
+			}
+			c, err := cluster.Get("demo")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if c.ConfPath != dir || c.GcloudProjectName != "proj" {
+				t.Errorf("imported cluster in %s of project %s", c.ConfPath, c.GcloudProjectName)
+			}
+			fi, err := os.Stat(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0700 {
+				t.Errorf("%s has mode %v, want 0700", dir, fi.Mode().Perm())
+			}
+
+			leftovers, _ := filepath.Glob(filepath.Join(home, ".demo.import-*"))
+			if len(leftovers) != 0 {
+				t.Errorf("import left %v behind", leftovers)
+			}
+		})
+	}
+}
+
+func TestImportBundleForcedFailureKeepsCluster(t *testing.T) {
+	bundle := exportDemo(t)
+	home := config.Home
+	stale := filepath.Join(home, "demo", "stale")
+	err := ioutil.WriteFile(stale, []byte("left by the existing cluster"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// serve the same store remotely, failing every write of the config
+	store := config.NewBackendHandler(config.NewLocalBackend(home))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/demo/config.json" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		store.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	config.StateStoreURI = srv.URL
+
+	err = importBundle(context.Background(), ImportBundleOptions{Path: bundle, Force: true})
+	if err == nil {
+		t.Fatal("importBundle succeeded while the config could not be written")
+	}
+
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("files of the existing cluster are gone: %v", err)
+	}
+	if _, err := cluster.Get("demo"); err != nil {
+		t.Errorf("config of the existing cluster is gone: %v", err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(home, ".demo.import-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("import left %v behind", leftovers)
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/cluster"
+)
+
+const (
+	kubeconfigUsageStr = "kubeconfig [cluster name]"
+)
+
+var (
+	kubeconfigUsageErrStr = fmt.Sprintf("expected '%s'.\ncluster name is a required argument for the kubeconfig command", kubeconfigUsageStr)
+)
+
+type KubeconfigOptions struct {
+	ClusterName string
+	Export      bool
+	Path        bool
+	Refresh     bool
+}
+
+func newKubeconfigOptions() *KubeconfigOptions {
+	return &KubeconfigOptions{}
+}
+
+// newKubeconfigCmd represents the kubeconfig command
+func newKubeconfigCmd() *cobra.Command {
+	o := newKubeconfigOptions()
+
+	cmd := &cobra.Command{
+		Use:   kubeconfigUsageStr,
+		Short: "Print the kubeconfig kmanager keeps for a cluster",
+		Long: `Print the kubeconfig kmanager keeps for a cluster.
+
+kmanager runs kubectl against a kubeconfig of its own in the config dir of
+every cluster and never touches ~/.kube/config. To use it in your shell run
+
+    eval $(kmanager kubeconfig <name> --export)`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := validate(args, kubeconfigUsageErrStr)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+
+			o.ClusterName = args[0]
+			err = printKubeconfig(cmd.Context(), cluster.DefaultExecutor, *o, os.Stdout)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while getting kubeconfig:", cluster.Explain(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *KubeconfigOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.Export, "export", false, "print a shell command setting KUBECONFIG to the kubeconfig")
+	cmd.Flags().BoolVar(&o.Path, "path", false, "print the path of the kubeconfig only")
+	cmd.Flags().BoolVar(&o.Refresh, "refresh", false, "fetch the credentials of the cluster again")
+}
+
+// printKubeconfig writes the kubeconfig of the cluster, its path or an
+// export of it to w, fetching it first when it does not exist yet.
+func printKubeconfig(ctx context.Context, e cluster.Executor, o KubeconfigOptions, w io.Writer) error {
+	if o.Export && o.Path {
+		return errors.New("--export and --path can not be combined")
+	}
+
+	c, err := getCluster(o.ClusterName, e)
+	if err != nil {
+		return err
+	}
+
+	if o.Refresh {
+		err = c.FetchKubeconfig(ctx)
+	} else {
+		err = c.EnsureKubeconfig(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := c.KubeconfigPath()
+	switch {
+	case o.Export:
+		_, err = fmt.Fprintf(w, "export KUBECONFIG=%q\n", path)
+	case o.Path:
+		_, err = fmt.Fprintln(w, path)
+	default:
+		var b []byte
+		b, err = ioutil.ReadFile(path)
+		if err == nil {
+			_, err = w.Write(b)
+		}
+	}
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(newKubeconfigCmd())
+}
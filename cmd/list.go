@@ -1,8 +1,8 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -15,7 +15,7 @@ var listCmd = &cobra.Command{
 	Short: "List cluster managed by current kmanager",
 	Long:  ``,
 	Run: func(cmd *cobra.Command, args []string) {
-		clusters, err := listClusters()
+		clusters, err := listClusters(cmd.Context())
 		if err != nil {
 			cmd.PrintErrln("Unable to list clusters, ", err)
 			os.Exit(1)
@@ -37,24 +37,11 @@ func init() {
 	rootCmd.AddCommand(listCmd)
 }
 
-func listClusters() ([]string, error) {
-	kmanagerConfPath, err := config.KmanagerConfigPath()
+func listClusters(ctx context.Context) ([]string, error) {
+	store, err := config.Store()
 	if err != nil {
 		return nil, err
 	}
 
-	fis, err := ioutil.ReadDir(kmanagerConfPath)
-	if err != nil {
-		return nil, err
-	}
-
-	var clusters []string
-
-	for _, fi := range fis {
-		if fi.IsDir() {
-			clusters = append(clusters, fi.Name())
-		}
-	}
-
-	return clusters, nil
+	return store.List(ctx)
 }
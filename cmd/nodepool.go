@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/cluster"
+)
+
+const (
+	nodePoolUsageStr       = "nodepool"
+	nodePoolAddUsageStr    = "add [cluster name] [node pool name]"
+	nodePoolListUsageStr   = "list [cluster name]"
+	nodePoolResizeUsageStr = "resize [cluster name] [node pool name]"
+	nodePoolDeleteUsageStr = "delete [cluster name] [node pool name]"
+)
+
+type NodePoolOptions struct {
+	ClusterName string
+	Pool        cluster.NodePool
+	MinNodes    int
+	MaxNodes    int
+}
+
+func newNodePoolOptions() *NodePoolOptions {
+	return &NodePoolOptions{
+		Pool: cluster.NodePool{
+			MachineType: cluster.DefaultMachineType,
+			NumNodes:    1,
+		},
+	}
+}
+
+// newNodePoolCmd represents the nodepool command group
+func newNodePoolCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   nodePoolUsageStr,
+		Short: "Manage the node pools of a cluster",
+	}
+
+	cmd.AddCommand(
+		newNodePoolAddCmd(),
+		newNodePoolListCmd(),
+		newNodePoolResizeCmd(),
+		newNodePoolDeleteCmd(),
+	)
+	return cmd
+}
+
+func newNodePoolAddCmd() *cobra.Command {
+	o := newNodePoolOptions()
+
+	cmd := &cobra.Command{
+		Use:   nodePoolAddUsageStr,
+		Short: "Add a node pool to the cluster of given name",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			o.ClusterName, o.Pool.Name = args[0], args[1]
+			if cmd.Flags().Changed("min-nodes") || cmd.Flags().Changed("max-nodes") {
+				o.Pool.Autoscaling = &cluster.Autoscaling{MinNodes: o.MinNodes, MaxNodes: o.MaxNodes}
+			}
+
+			err := addNodePool(cmd.Context(), cluster.DefaultExecutor, *o)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while adding node pool:", cluster.Explain(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&o.Pool.MachineType, "machine-type", o.Pool.MachineType, "machine type of the nodes")
+	cmd.Flags().IntVar(&o.Pool.NumNodes, "num-nodes", o.Pool.NumNodes, "number of nodes per zone")
+	cmd.Flags().IntVar(&o.Pool.DiskSizeGB, "disk-size", 0, "boot disk size of the nodes in GB, defaults to the GKE default")
+	cmd.Flags().BoolVar(&o.Pool.Spot, "spot", false, "use spot nodes")
+	cmd.Flags().BoolVar(&o.Pool.Preemptible, "preemptible", false, "use preemptible nodes")
+	cmd.Flags().StringSliceVar(&o.Pool.Taints, "taints", nil, "taints of the nodes as key=value:effect")
+	cmd.Flags().StringToStringVar(&o.Pool.Labels, "labels", nil, "kubernetes labels of the nodes as key=value")
+	o.addAutoscalingFlags(cmd)
+	return cmd
+}
+
+func newNodePoolListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   nodePoolListUsageStr,
+		Short: "List the node pools of the cluster of given name",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := listNodePools(cmd.Context(), cluster.DefaultExecutor, args[0])
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while listing node pools:", cluster.Explain(err))
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+func newNodePoolResizeCmd() *cobra.Command {
+	o := newNodePoolOptions()
+
+	cmd := &cobra.Command{
+		Use:   nodePoolResizeUsageStr,
+		Short: "Change the number of nodes or the autoscaling bounds of a node pool",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			o.ClusterName, o.Pool.Name = args[0], args[1]
+			resize := cmd.Flags().Changed("num-nodes")
+			if cmd.Flags().Changed("min-nodes") || cmd.Flags().Changed("max-nodes") {
+				o.Pool.Autoscaling = &cluster.Autoscaling{MinNodes: o.MinNodes, MaxNodes: o.MaxNodes}
+			}
+			if !resize && o.Pool.Autoscaling == nil {
+				cmd.PrintErrln("expected --num-nodes or --min-nodes and --max-nodes")
+				os.Exit(1)
+			}
+
+			err := resizeNodePool(cmd.Context(), cluster.DefaultExecutor, *o, resize)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while resizing node pool:", cluster.Explain(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().IntVar(&o.Pool.NumNodes, "num-nodes", 0, "number of nodes per zone")
+	o.addAutoscalingFlags(cmd)
+	return cmd
+}
+
+func newNodePoolDeleteCmd() *cobra.Command {
+	o := newNodePoolOptions()
+
+	cmd := &cobra.Command{
+		Use:   nodePoolDeleteUsageStr,
+		Short: "Delete a node pool of the cluster of given name",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			o.ClusterName, o.Pool.Name = args[0], args[1]
+
+			err := deleteNodePool(cmd.Context(), cluster.DefaultExecutor, *o)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while deleting node pool:", cluster.Explain(err))
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+func (o *NodePoolOptions) addAutoscalingFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&o.MinNodes, "min-nodes", 0, "enable autoscaling with this minimum number of nodes per zone")
+	cmd.Flags().IntVar(&o.MaxNodes, "max-nodes", 0, "enable autoscaling with this maximum number of nodes per zone")
+}
+
+// getCluster loads the config of the named cluster, running its commands
+// through e.
+func getCluster(name string, e cluster.Executor) (*cluster.Cluster, error) {
+	c, err := cluster.Get(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("No config file found of cluster named \"%s\"", name)
+	} else if err != nil {
+		return nil, err
+	}
+	c.Exec = e
+	return &c, nil
+}
+
+func addNodePool(ctx context.Context, e cluster.Executor, o NodePoolOptions) error {
+	err := o.Pool.Validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, unlock, err := lockCluster(ctx, o.ClusterName, "nodepool add")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c, err := getCluster(o.ClusterName, e)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.NodePool(o.Pool.Name); ok {
+		return fmt.Errorf("node pool \"%s\" already exists in cluster \"%s\"", o.Pool.Name, c.Name)
+	}
+
+	createNodePoolCmd := cluster.Command{
+		Name:    "create-node-pool",
+		RootCmd: "gcloud",
+		Timeout: 30 * time.Minute,
+		Args:    c.CreateNodePoolArgs(o.Pool),
+	}
+
+	createNodePoolCmd.Execute(ctx, c)
+	if !createNodePoolCmd.Succeed {
+		return createNodePoolCmd.Stderr
+	}
+
+	c.SetNodePool(o.Pool)
+	return c.GenerateConfig()
+}
+
+func listNodePools(ctx context.Context, e cluster.Executor, name string) error {
+	c, err := getCluster(name, e)
+	if err != nil {
+		return err
+	}
+
+	listNodePoolsCmd := cluster.Command{
+		Name:     "list-node-pools",
+		RootCmd:  "gcloud",
+		ReadOnly: true,
+		Args:     c.ListNodePoolsArgs(),
+	}
+
+	listNodePoolsCmd.Execute(ctx, c)
+	if !listNodePoolsCmd.Succeed {
+		return listNodePoolsCmd.Stderr
+	}
+
+	fmt.Println(listNodePoolsCmd.Stdout)
+	return nil
+}
+
+func resizeNodePool(ctx context.Context, e cluster.Executor, o NodePoolOptions, resize bool) error {
+	if o.Pool.Autoscaling != nil {
+		err := o.Pool.Autoscaling.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if resize && o.Pool.NumNodes < 0 {
+		return fmt.Errorf("number of nodes can not be negative, got %d", o.Pool.NumNodes)
+	}
+
+	ctx, unlock, err := lockCluster(ctx, o.ClusterName, "nodepool resize")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c, err := getCluster(o.ClusterName, e)
+	if err != nil {
+		return err
+	}
+
+	pool, ok := c.NodePool(o.Pool.Name)
+	if !ok {
+		return fmt.Errorf("node pool \"%s\" is not managed by kmanager in cluster \"%s\"", o.Pool.Name, c.Name)
+	}
+
+	if o.Pool.Autoscaling != nil {
+		autoscaleNodePoolCmd := cluster.Command{
+			Name:    "autoscale-node-pool",
+			RootCmd: "gcloud",
+			Timeout: 30 * time.Minute,
+			Args:    c.AutoscaleNodePoolArgs(pool.Name, *o.Pool.Autoscaling),
+		}
+
+		autoscaleNodePoolCmd.Execute(ctx, c)
+		if !autoscaleNodePoolCmd.Succeed {
+			return autoscaleNodePoolCmd.Stderr
+		}
+
+		// record the new bounds even if the resize below fails
+		pool.Autoscaling = o.Pool.Autoscaling
+		c.SetNodePool(pool)
+		err = c.GenerateConfig()
+		if err != nil {
+			return err
+		}
+	}
+
+	if resize {
+		resizeNodePoolCmd := cluster.Command{
+			Name:    "resize-node-pool",
+			RootCmd: "gcloud",
+			Timeout: 30 * time.Minute,
+			Args:    c.ResizeNodePoolArgs(pool.Name, o.Pool.NumNodes),
+		}
+
+		resizeNodePoolCmd.Execute(ctx, c)
+		if !resizeNodePoolCmd.Succeed {
+			return resizeNodePoolCmd.Stderr
+		}
+
+		pool.NumNodes = o.Pool.NumNodes
+		c.SetNodePool(pool)
+		err = c.GenerateConfig()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteNodePool(ctx context.Context, e cluster.Executor, o NodePoolOptions) error {
+	ctx, unlock, err := lockCluster(ctx, o.ClusterName, "nodepool delete")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c, err := getCluster(o.ClusterName, e)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.NodePool(o.Pool.Name); !ok {
+		return fmt.Errorf("node pool \"%s\" is not managed by kmanager in cluster \"%s\"", o.Pool.Name, c.Name)
+	}
+
+	err = DeleteNodePool(ctx, *c, o.Pool.Name)
+	if err != nil && !errors.Is(err, cluster.ErrNotFound) {
+		return err
+	}
+
+	c.RemoveNodePool(o.Pool.Name)
+	return c.GenerateConfig()
+}
+
+func DeleteNodePool(ctx context.Context, c cluster.Cluster, name string) error {
+	deleteNodePoolCmd := cluster.Command{
+		Name:    "delete-node-pool",
+		RootCmd: "gcloud",
+		Timeout: 30 * time.Minute,
+		Args:    c.DeleteNodePoolArgs(name),
+	}
+
+	deleteNodePoolCmd.Execute(ctx, &c)
+	if !deleteNodePoolCmd.Succeed {
+		return deleteNodePoolCmd.Stderr
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newNodePoolCmd())
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/urvil38/kmanager/cluster"
+	"github.com/urvil38/kmanager/config"
+)
+
+// storedNodePools reads the node pools of demo from its config.json.
+func storedNodePools(t *testing.T) []cluster.NodePool {
+	b, err := ioutil.ReadFile(filepath.Join(config.Home, "demo", config.ConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c struct {
+		NodePools []cluster.NodePool `json:"node_pools"`
+	}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.NodePools
+}
+
+// storeNodePool records demo with the node pool of the given name, one
+// n1-standard-1 node per zone.
+func storeNodePool(t *testing.T, name string) cluster.NodePool {
+	storeCluster(t)
+
+	pool := cluster.NodePool{Name: name, MachineType: cluster.DefaultMachineType, NumNodes: 1}
+	err := addNodePool(context.Background(), cluster.NewFakeExecutor(cluster.FakeResponse{RootCmd: "gcloud"}), NodePoolOptions{ClusterName: "demo", Pool: pool})
+	if err != nil {
+		t.Fatalf("addNodePool: %v", err)
+	}
+	return pool
+}
+
+func TestAddNodePool(t *testing.T) {
+	pool := storeNodePool(t, "default")
+
+	gpu := cluster.NodePool{
+		Name:        "gpu",
+		MachineType: "n1-standard-4",
+		NumNodes:    2,
+		Spot:        true,
+		Autoscaling: &cluster.Autoscaling{MinNodes: 1, MaxNodes: 3},
+		Taints:      []string{"gpu=true:NoSchedule"},
+	}
+	f := cluster.NewFakeExecutor(cluster.FakeResponse{RootCmd: "gcloud"})
+	err := addNodePool(context.Background(), f, NodePoolOptions{ClusterName: "demo", Pool: gpu})
+	if err != nil {
+		t.Fatalf("addNodePool: %v", err)
+	}
+
+	want := []string{
+		"container", "node-pools", "create", "gpu",
+		"--cluster", "demo", "--project", "proj",
+		"--machine-type", "n1-standard-4", "--num-nodes=2",
+		"--zone", "us-central1-a",
+		"--spot",
+		"--enable-autoscaling", "--min-nodes=1", "--max-nodes=3",
+		"--node-taints", "gpu=true:NoSchedule",
+		"--workload-metadata", "GKE_METADATA",
+		"--labels", "kmanager-cluster=demo,managed-by=kmanager",
+	}
+	if len(f.Calls) != 1 || !reflect.DeepEqual(f.Calls[0].Args, want) {
+		t.Errorf("ran %v, want gcloud %s", f.Calls, strings.Join(want, " "))
+	}
+	if got := storedNodePools(t); !reflect.DeepEqual(got, []cluster.NodePool{pool, gpu}) {
+		t.Errorf("stored node pools %+v, want %+v", got, []cluster.NodePool{pool, gpu})
+	}
+
+	f = cluster.NewFakeExecutor(cluster.FakeResponse{RootCmd: "gcloud"})
+	err = addNodePool(context.Background(), f, NodePoolOptions{ClusterName: "demo", Pool: gpu})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("adding node pool gpu again = %v, want it to exist already", err)
+	}
+	if len(f.Calls) != 0 {
+		t.Errorf("adding an existing node pool ran %v", f.Calls)
+	}
+}
+
+func TestAddNodePoolFailure(t *testing.T) {
+	storeCluster(t)
+
+	f := cluster.NewFakeExecutor(cluster.FakeResponse{RootCmd: "gcloud", ExitCode: 1, Stderr: "ERROR: (gcloud.container.node-pools.create) PERMISSION_DENIED: Forbidden"})
+	err := addNodePool(context.Background(), f, NodePoolOptions{ClusterName: "demo", Pool: cluster.NodePool{Name: "gpu", MachineType: "n1-standard-4", NumNodes: 1}})
+	if err == nil {
+		t.Fatal("addNodePool succeeded with a failed create")
+	}
+	if got := storedNodePools(t); len(got) != 0 {
+		t.Errorf("failed node pool recorded: %+v", got)
+	}
+}
+
+func TestResizeNodePool(t *testing.T) {
+	autoscaling := &cluster.Autoscaling{MinNodes: 2, MaxNodes: 5}
+	autoscale := []string{"container", "clusters", "update", "demo", "--node-pool", "default", "--project", "proj", "--zone", "us-central1-a", "--enable-autoscaling", "--min-nodes=2", "--max-nodes=5"}
+	resize := []string{"container", "clusters", "resize", "demo", "--node-pool", "default", "--num-nodes=3", "--project", "proj", "--quiet", "--zone", "us-central1-a"}
+	failed := cluster.FakeResponse{RootCmd: "gcloud", Args: resize, ExitCode: 1, Stderr: "ERROR: (gcloud.container.clusters.resize) QUOTA_EXCEEDED: Insufficient regional quota"}
+
+	tests := []struct {
+		name      string
+		pool      string
+		responses []cluster.FakeResponse
+		calls     [][]string
+		wantErr   bool
+		want      []cluster.NodePool
+	}{
+		{
+			name:  "resize and autoscale",
+			pool:  "default",
+			calls: [][]string{autoscale, resize},
+			want:  []cluster.NodePool{{Name: "default", MachineType: cluster.DefaultMachineType, NumNodes: 3, Autoscaling: autoscaling}},
+		},
+		{
+			name:      "failed resize keeps autoscaling",
+			pool:      "default",
+			responses: []cluster.FakeResponse{failed},
+			calls:     [][]string{autoscale, resize},
+			wantErr:   true,
+			want:      []cluster.NodePool{{Name: "default", MachineType: cluster.DefaultMachineType, NumNodes: 1, Autoscaling: autoscaling}},
+		},
+		{
+			name:    "unmanaged pool",
+			pool:    "other",
+			wantErr: true,
+			want:    []cluster.NodePool{{Name: "default", MachineType: cluster.DefaultMachineType, NumNodes: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeNodePool(t, "default")
+
+			f := cluster.NewFakeExecutor(append(tt.responses, cluster.FakeResponse{RootCmd: "gcloud"})...)
+			o := NodePoolOptions{ClusterName: "demo", Pool: cluster.NodePool{Name: tt.pool, NumNodes: 3, Autoscaling: autoscaling}}
+			err := resizeNodePool(context.Background(), f, o, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resizeNodePool = %v, want error %v", err, tt.wantErr)
+			}
+
+			var calls [][]string
+			for _, p := range f.Calls {
+				calls = append(calls, p.Args)
+			}
+			if !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("ran %q, want %q", calls, tt.calls)
+			}
+			if got := storedNodePools(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored node pools %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteNodePool(t *testing.T) {
+	remove := []string{"container", "node-pools", "delete", "default", "--cluster", "demo", "--project", "proj", "--quiet", "--zone", "us-central1-a"}
+	pool := cluster.NodePool{Name: "default", MachineType: cluster.DefaultMachineType, NumNodes: 1}
+
+	tests := []struct {
+		name     string
+		pool     string
+		response cluster.FakeResponse
+		calls    int
+		wantErr  bool
+		want     []cluster.NodePool
+	}{
+		{"deleted", "default", cluster.FakeResponse{RootCmd: "gcloud"}, 1, false, nil},
+		{"already deleted", "default", cluster.FakeResponse{RootCmd: "gcloud", ExitCode: 1, Stderr: "ERROR: (gcloud.container.node-pools.delete) NOT_FOUND: Not found: node pool \"default\""}, 1, false, nil},
+		{"failed delete", "default", cluster.FakeResponse{RootCmd: "gcloud", ExitCode: 1, Stderr: "ERROR: (gcloud.container.node-pools.delete) PERMISSION_DENIED: Forbidden"}, 1, true, []cluster.NodePool{pool}},
+		{"unmanaged pool", "other", cluster.FakeResponse{RootCmd: "gcloud"}, 0, true, []cluster.NodePool{pool}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeNodePool(t, "default")
+
+			f := cluster.NewFakeExecutor(tt.response)
+			err := deleteNodePool(context.Background(), f, NodePoolOptions{ClusterName: "demo", Pool: cluster.NodePool{Name: tt.pool}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deleteNodePool = %v, want error %v", err, tt.wantErr)
+			}
+
+			if len(f.Calls) != tt.calls {
+				t.Errorf("ran %d commands, want %d", len(f.Calls), tt.calls)
+			}
+			if n := f.Called("gcloud", remove...); tt.calls > 0 && n != 1 {
+				t.Errorf("gcloud %s run %d times, want once", strings.Join(remove, " "), n)
+			}
+			if got := storedNodePools(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored node pools %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urvil38/kmanager/cluster"
+)
+
+// undoActions maps the steps of cluster creation which leave resources
+// behind to the action tearing them down again. Steps without an entry,
+// like IAM bindings and kubeapps, go away with the resources they live on.
+var undoActions = map[string]func(context.Context, cluster.Cluster) error{
+	"create-dns-zone":           DeleteDNSZone,
+	"create-kubernetes-cluster": DeleteKubernetesCluster,
+	"create-storage-bucket-soucecode": func(ctx context.Context, c cluster.Cluster) error {
+		c.Storage = cluster.Storage{SourceCodeBucket: c.Storage.SourceCodeBucket}
+		return DeleteStorageBuckets(ctx, c)
+	},
+	"create-storage-bucket-cloudbuild-logs": func(ctx context.Context, c cluster.Cluster) error {
+		c.Storage = cluster.Storage{CloudBuildBucket: c.Storage.CloudBuildBucket}
+		return DeleteStorageBuckets(ctx, c)
+	},
+	"create-clouddns-service-account": func(ctx context.Context, c cluster.Cluster) error {
+		c.ServiceAccount = cluster.ServiceAccount{DNS: c.ServiceAccount.DNS}
+		return DeleteServiceAccounts(ctx, c)
+	},
+	"create-storage-service-account": func(ctx context.Context, c cluster.Cluster) error {
+		c.ServiceAccount = cluster.ServiceAccount{Storage: c.ServiceAccount.Storage}
+		return DeleteServiceAccounts(ctx, c)
+	},
+	"create-cloudbuild-service-account": func(ctx context.Context, c cluster.Cluster) error {
+		c.ServiceAccount = cluster.ServiceAccount{CloudBuild: c.ServiceAccount.CloudBuild}
+		return DeleteServiceAccounts(ctx, c)
+	},
+}
+
+// rollbackCluster tears down everything the journal of c records as
+// created, in reverse order. The config dir is only removed when every undo
+// action succeeded, so a partial rollback can still be finished with delete.
+func rollbackCluster(ctx context.Context, c cluster.Cluster) error {
+	if c.Journal == nil {
+		return fmt.Errorf("no journal found for cluster \"%s\", unable to roll back", c.Name)
+	}
+
+	fmt.Printf("rolling back partially created cluster \"%s\"\n", c.Name)
+
+	steps := c.Journal.Completed()
+	failed := 0
+	for i := len(steps) - 1; i >= 0; i-- {
+		undo, isthere := undoActions[steps[i]]
+		if !isthere {
+			continue
+		}
+
+		err := undo(ctx, c)
+		if err != nil {
+			fmt.Printf("undo %s: %v\n", steps[i], err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("rollback of cluster \"%s\" left %d resource(s) behind, run 'kmanager delete %s' to retry", c.Name, failed, c.Name)
+	}
+
+	return c.RemoveConfig()
+}
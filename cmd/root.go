@@ -1,14 +1,18 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/config"
 )
 
 const (
@@ -27,6 +31,9 @@ const (
 var rootCmd = &cobra.Command{
 	Use:   "kmanager",
 	Short: "Cluster Manager of KubePAAS platform",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		checkRequirements()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		printBanner()
 		_ = cmd.Help()
@@ -35,20 +42,45 @@ var rootCmd = &cobra.Command{
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The context handed to the commands is cancelled on the first interrupt,
+// a second one exits right away.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		color.HiYellow("\ninterrupted, waiting for running steps to stop (press Ctrl-C again to force)")
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
+func init() {
+	rootCmd.PersistentFlags().StringVar(&config.StateStoreURI, "state-store", config.StateStoreURI,
+		fmt.Sprintf("where cluster configs are kept: local, file:///dir or an http(s) url, defaults to $%s", config.StateStoreEnv))
+	rootCmd.PersistentFlags().StringVar(&config.Home, "config-dir", config.Home,
+		fmt.Sprintf("kmanager config dir holding the working files of every cluster, defaults to $%s or the user config dir", config.HomeEnv))
+}
+
 func printBanner() {
 	rand.Seed(time.Now().UnixNano())
 	colorCounter := rand.Intn(7)
 	fmt.Printf("\x1b[1;3%dm%v\x1b[0m", colorCounter+1, banner)
 }
 
-func init() {
+// checkRequirements exits when kubectl or gcloud is missing. It runs before
+// every command rather than at init time so the package can be loaded
+// without the cloud CLIs installed.
+func checkRequirements() {
 	kubectlCmd := exec.Command("kubectl", "version", "--client", "--short")
 
 	err := kubectlCmd.Run()
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/cluster"
+)
+
+const (
+	statusUsageStr = "status [cluster name]"
+)
+
+var (
+	statusUsageErrStr = fmt.Sprintf("expected '%s'.\ncluster name is a required argument for the status command", statusUsageStr)
+)
+
+// Exit codes of the status command, following the convention of
+// monitoring plugins so it can be used as a health check as is.
+const (
+	statusExitOK      = 0
+	statusExitDrifted = 1
+	statusExitMissing = 2
+	statusExitUnknown = 3
+)
+
+type StatusOptions struct {
+	ClusterName string
+	JSON        bool
+}
+
+func newStatusOptions() *StatusOptions {
+	return &StatusOptions{}
+}
+
+// newStatusCmd represents the status command
+func newStatusCmd() *cobra.Command {
+	o := newStatusOptions()
+
+	cmd := &cobra.Command{
+		Use:   statusUsageStr,
+		Short: "Compare the resources recorded for a cluster with what exists",
+		Long: `Compare the resources recorded for a cluster with what exists.
+
+The exit code is 0 when every resource is present as recorded, 1 when some
+resource drifted, 2 when some resource is missing and 3 when some resource
+could not be checked.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := validate(args, statusUsageErrStr)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(statusExitUnknown)
+			}
+
+			o.ClusterName = args[0]
+			code, err := clusterStatus(cmd.Context(), cluster.DefaultExecutor, *o, os.Stdout)
+			if err != nil {
+				cmd.PrintErrln("Oops, got error while checking cluster status:", cluster.Explain(err))
+				os.Exit(statusExitUnknown)
+			}
+			os.Exit(code)
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *StatusOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.JSON, "json", false, "print the status as JSON")
+}
+
+// clusterStatus writes the status of every resource of the cluster to w
+// and returns the exit code summarizing it.
+func clusterStatus(ctx context.Context, e cluster.Executor, o StatusOptions, w io.Writer) (int, error) {
+	c, err := getCluster(o.ClusterName, e)
+	if err != nil {
+		return statusExitUnknown, err
+	}
+
+	resources := c.Status(ctx)
+	if ctx.Err() != nil {
+		return statusExitUnknown, ctx.Err()
+	}
+
+	overall, code := cluster.StatusPresent, statusExitOK
+	counts := make(map[string]int)
+	for _, r := range resources {
+		counts[r.Status]++
+	}
+	switch {
+	case counts[cluster.StatusMissing] > 0:
+		overall, code = cluster.StatusMissing, statusExitMissing
+	case counts[cluster.StatusUnknown] > 0:
+		overall, code = cluster.StatusUnknown, statusExitUnknown
+	case counts[cluster.StatusDrifted] > 0:
+		overall, code = cluster.StatusDrifted, statusExitDrifted
+	}
+
+	if o.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(struct {
+			Cluster   string                   `json:"cluster"`
+			Status    string                   `json:"status"`
+			Resources []cluster.ResourceStatus `json:"resources"`
+		}{c.Name, overall, resources})
+		return code, err
+	}
+
+	colors := map[string]*color.Color{
+		cluster.StatusPresent: color.New(color.FgHiGreen),
+		cluster.StatusDrifted: color.New(color.FgHiYellow),
+		cluster.StatusMissing: color.New(color.FgHiRed),
+		cluster.StatusUnknown: color.New(color.FgHiMagenta),
+	}
+	for _, r := range resources {
+		fmt.Fprintf(w, "%-20s %s %s\n", r.Kind, colors[r.Status].Sprintf("%-8s", r.Status), r.Name)
+		for _, d := range r.Drift {
+			fmt.Fprintf(w, "    %s\n", d)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(w, "    %s\n", r.Error)
+		}
+	}
+
+	var summary []string
+	for _, s := range []string{cluster.StatusPresent, cluster.StatusDrifted, cluster.StatusMissing, cluster.StatusUnknown} {
+		if counts[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	fmt.Fprintf(w, "\ncluster \"%s\" is %s: %s\n", c.Name, colors[overall].Sprint(overall), strings.Join(summary, ", "))
+	return code, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newStatusCmd())
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urvil38/kmanager/cluster"
+)
+
+const (
+	dnsSA        = "demo-cert-clouddns@proj.iam.gserviceaccount.com"
+	storageSA    = "demo-storage@proj.iam.gserviceaccount.com"
+	cloudbuildSA = "demo-cloudbuild@proj.iam.gserviceaccount.com"
+)
+
+// statusResponses answers the lookups of the status of demo, as stored by
+// storeCluster with the kubeapp nginx, with everything present as
+// recorded. The responses in first take precedence.
+func statusResponses(manifest string, first ...cluster.FakeResponse) []cluster.FakeResponse {
+	labels := `{"kmanager-cluster":"demo","managed-by":"kmanager"}`
+	gke := fmt.Sprintf(`{"name":"demo","status":"RUNNING","resourceLabels":%s,"workloadIdentityConfig":{"workloadPool":"proj.svc.id.goog"},"nodePools":[{"name":"default-pool","config":{"machineType":%q,"diskSizeGb":%d,"diskType":%q,"imageType":"COS_CONTAINERD","preemptible":true}}]}`,
+		labels, cluster.DefaultMachineType, cluster.DefaultDiskSizeGB, cluster.DefaultDiskType)
+	wiPolicy := func(ns, ksa string) string {
+		return fmt.Sprintf(`{"bindings":[{"role":"roles/iam.workloadIdentityUser","members":["serviceAccount:proj.svc.id.goog[%s/%s]"]}]}`, ns, ksa)
+	}
+
+	return append(first,
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"container", "clusters", "describe", "demo", "--project", "proj", "--zone", "us-central1-a", "--format", "json"}, Stdout: gke},
+		cluster.FakeResponse{RootCmd: "gsutil", Args: []string{"label", "get", "gs://demo-sourcecode"}, Stdout: labels},
+		cluster.FakeResponse{RootCmd: "gsutil", Args: []string{"label", "get", "gs://demo-cloudbuild-logs"}, Stdout: labels},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"dns", "managed-zones", "describe", "demo", "--project", "proj", "--format", "json"}, Stdout: `{"dnsName":"demo.example.com.","labels":` + labels + `}`},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "describe", dnsSA, "--project", "proj", "--format", "json"}, Stdout: `{}`},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "describe", storageSA, "--project", "proj", "--format", "json"}, Stdout: `{}`},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "describe", cloudbuildSA, "--project", "proj", "--format", "json"}, Stdout: `{}`},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"projects", "get-iam-policy", "proj", "--format", "json"}, Stdout: `{"bindings":[` +
+			`{"role":"roles/dns.admin","members":["serviceAccount:` + dnsSA + `"]},` +
+			`{"role":"roles/cloudbuild.builds.editor","members":["serviceAccount:` + cloudbuildSA + `"]}]}`},
+		cluster.FakeResponse{RootCmd: "gsutil", Args: []string{"iam", "get", "gs://demo-sourcecode"}, Stdout: `{"bindings":[{"role":"roles/storage.objectCreator","members":["serviceAccount:` + storageSA + `"]}]}`},
+		cluster.FakeResponse{RootCmd: "gsutil", Args: []string{"iam", "get", "gs://demo-cloudbuild-logs"}, Stdout: `{"bindings":[{"role":"roles/storage.objectViewer","members":["serviceAccount:` + storageSA + `"]}]}`},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "get-iam-policy", dnsSA, "--project", "proj", "--format", "json"}, Stdout: wiPolicy("cert-manager", "cert-manager")},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "get-iam-policy", cloudbuildSA, "--project", "proj", "--format", "json"}, Stdout: wiPolicy("generator", "demo-cloudbuild")},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "get-iam-policy", storageSA, "--project", "proj", "--format", "json"}, Stdout: wiPolicy("generator", "demo-storage")},
+		cluster.FakeResponse{RootCmd: "gcloud", Args: []string{"container", "clusters", "get-credentials", "demo", "--project", "proj", "--zone", "us-central1-a"}},
+		cluster.FakeResponse{RootCmd: "kubectl", Args: []string{"get", "-f", manifest, "-o", "name"}, Stdout: "configmap/nginx\n"},
+		cluster.FakeResponse{RootCmd: "kubectl", Args: []string{"diff", "-f", manifest}},
+	)
+}
+
+func TestClusterStatus(t *testing.T) {
+	type want struct {
+		kind, name, status string
+		drift              []string
+		err                string
+	}
+
+	tests := []struct {
+		name      string
+		responses func(manifest string) []cluster.FakeResponse
+		overall   string
+		code      int
+		changed   []want
+	}{
+		{
+			name:      "present",
+			responses: func(string) []cluster.FakeResponse { return nil },
+			overall:   cluster.StatusPresent,
+			code:      statusExitOK,
+		},
+		{
+			name: "missing bucket",
+			responses: func(string) []cluster.FakeResponse {
+				return []cluster.FakeResponse{{RootCmd: "gsutil", Args: []string{"label", "get", "gs://demo-sourcecode"}, ExitCode: 1, Stderr: "BucketNotFoundException: 404 gs://demo-sourcecode bucket does not exist."}}
+			},
+			overall: cluster.StatusMissing,
+			code:    statusExitMissing,
+			changed: []want{{"storage-bucket", "demo-sourcecode", cluster.StatusMissing, nil, cluster.ErrNotFound.Error()}},
+		},
+		{
+			name: "drifted labels",
+			responses: func(string) []cluster.FakeResponse {
+				return []cluster.FakeResponse{{RootCmd: "gcloud", Args: []string{"dns", "managed-zones", "describe", "demo", "--project", "proj", "--format", "json"}, Stdout: `{"dnsName":"demo.example.com.","labels":{"kmanager-cluster":"other"}}`}}
+			},
+			overall: cluster.StatusDrifted,
+			code:    statusExitDrifted,
+			changed: []want{{"dns-zone", "demo", cluster.StatusDrifted, []string{`label kmanager-cluster is "other", expected "demo"`, "label managed-by is missing"}, ""}},
+		},
+		{
+			name: "drifted iam binding",
+			responses: func(string) []cluster.FakeResponse {
+				return []cluster.FakeResponse{{RootCmd: "gcloud", Args: []string{"projects", "get-iam-policy", "proj", "--format", "json"}, Stdout: `{"bindings":[{"role":"roles/cloudbuild.builds.editor","members":["serviceAccount:` + cloudbuildSA + `"]}]}`}}
+			},
+			overall: cluster.StatusDrifted,
+			code:    statusExitDrifted,
+			changed: []want{{"iam-binding", "serviceAccount:" + dnsSA + " roles/dns.admin on project proj", cluster.StatusDrifted, []string{"binding is missing from the policy"}, ""}},
+		},
+		{
+			name: "drifted kubeapp",
+			responses: func(manifest string) []cluster.FakeResponse {
+				return []cluster.FakeResponse{{RootCmd: "kubectl", Args: []string{"diff", "-f", manifest}, ExitCode: 1, Stdout: "-data: {}\n+data: {a: b}\n"}}
+			},
+			overall: cluster.StatusDrifted,
+			code:    statusExitDrifted,
+			changed: []want{{"kubeapp", "nginx", cluster.StatusDrifted, nil, ""}},
+		},
+		{
+			name: "unknown service account",
+			responses: func(string) []cluster.FakeResponse {
+				return []cluster.FakeResponse{{RootCmd: "gcloud", Args: []string{"iam", "service-accounts", "describe", storageSA, "--project", "proj", "--format", "json"}, ExitCode: 1, Stderr: "ERROR: (gcloud.iam.service-accounts.describe) INTERNAL: backend error"}}
+			},
+			overall: cluster.StatusUnknown,
+			code:    statusExitUnknown,
+			changed: []want{{"service-account", storageSA, cluster.StatusUnknown, nil, "ERROR: (gcloud.iam.service-accounts.describe) INTERNAL: backend error"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeCluster(t, "nginx")
+			c, err := cluster.Get("demo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest := filepath.Join(c.ConfPath, "nginx.yaml")
+
+			var out bytes.Buffer
+			f := cluster.NewFakeExecutor(statusResponses(manifest, tt.responses(manifest)...)...)
+			code, err := clusterStatus(context.Background(), f, StatusOptions{ClusterName: "demo", JSON: true}, &out)
+			if err != nil {
+				t.Fatalf("clusterStatus: %v", err)
+			}
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d", code, tt.code)
+			}
+
+			var report struct {
+				Cluster   string                   `json:"cluster"`
+				Status    string                   `json:"status"`
+				Resources []cluster.ResourceStatus `json:"resources"`
+			}
+			err = json.Unmarshal(out.Bytes(), &report)
+			if err != nil {
+				t.Fatalf("unexpected JSON output: %v\n%s", err, out.String())
+			}
+			if report.Cluster != "demo" || report.Status != tt.overall {
+				t.Errorf("cluster %q is %q, want demo is %q", report.Cluster, report.Status, tt.overall)
+			}
+			// the cluster, 2 buckets, the zone, 3 service accounts, 7 IAM
+			// bindings and the kubeapp
+			if len(report.Resources) != 15 {
+				t.Errorf("%d resources reported, want 15:\n%s", len(report.Resources), out.String())
+			}
+
+			for _, r := range report.Resources {
+				w := want{kind: r.Kind, name: r.Name, status: cluster.StatusPresent}
+				for _, c := range tt.changed {
+					if c.kind == r.Kind && c.name == r.Name {
+						w = c
+					}
+				}
+				if r.Status != w.status {
+					t.Errorf("%s %s is %s, want %s (%v)", r.Kind, r.Name, r.Status, w.status, r.Error)
+				}
+				if w.drift != nil && strings.Join(r.Drift, "\n") != strings.Join(w.drift, "\n") {
+					t.Errorf("%s %s drift = %q, want %q", r.Kind, r.Name, r.Drift, w.drift)
+				}
+				if w.status == cluster.StatusDrifted && len(r.Drift) == 0 {
+					t.Errorf("%s %s drifted without saying how", r.Kind, r.Name)
+				}
+				if r.Error != w.err {
+					t.Errorf("%s %s error = %q, want %q", r.Kind, r.Name, r.Error, w.err)
+				}
+			}
+
+			out.Reset()
+			f = cluster.NewFakeExecutor(statusResponses(manifest, tt.responses(manifest)...)...)
+			code, err = clusterStatus(context.Background(), f, StatusOptions{ClusterName: "demo"}, &out)
+			if err != nil || code != tt.code {
+				t.Errorf("clusterStatus without --json = %d, %v, want %d", code, err, tt.code)
+			}
+			if summary := fmt.Sprintf("cluster \"demo\" is %s:", tt.overall); !strings.Contains(out.String(), summary) {
+				t.Errorf("output lacks %q:\n%s", summary, out.String())
+			}
+		})
+	}
+}
+
+func TestClusterStatusNotFound(t *testing.T) {
+	testHome(t)
+
+	code, err := clusterStatus(context.Background(), cluster.NewFakeExecutor(), StatusOptions{ClusterName: "demo"}, &bytes.Buffer{})
+	if err == nil || code != statusExitUnknown {
+		t.Errorf("clusterStatus of an unknown cluster = %d, %v, want %d and an error", code, err, statusExitUnknown)
+	}
+}
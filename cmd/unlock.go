@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/urvil38/kmanager/config"
+)
+
+const (
+	unlockUsageStr = "unlock [cluster name]"
+)
+
+var (
+	unlockUsageErrStr = fmt.Sprintf("expected '%s'.\ncluster name is a required argument for the unlock command", unlockUsageStr)
+)
+
+type UnlockOptions struct {
+	ClusterName string
+	Force       bool
+}
+
+func newUnlockOptions() *UnlockOptions {
+	return &UnlockOptions{}
+}
+
+// newUnlockCmd represents the unlock command
+func newUnlockCmd() *cobra.Command {
+	o := newUnlockOptions()
+
+	cmd := &cobra.Command{
+		Use:   unlockUsageStr,
+		Short: "Remove the lock of a cluster left behind by an interrupted run",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := validate(args, unlockUsageErrStr)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+
+			o.ClusterName = args[0]
+			err = unlockCluster(cmd.Context(), *o)
+			if err != nil {
+				cmd.PrintErrln(err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	o.addFlags(cmd)
+	return cmd
+}
+
+func (o *UnlockOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.Force, "force", false, "remove the lock even though its holder may still be running")
+}
+
+func unlockCluster(ctx context.Context, o UnlockOptions) error {
+	store, err := config.Store()
+	if err != nil {
+		return err
+	}
+
+	lease, err := store.GetLease(ctx, o.ClusterName)
+	if errors.Is(err, config.ErrStateNotFound) {
+		fmt.Printf("cluster \"%s\" is not locked\n", o.ClusterName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Println(config.Describe(lease))
+	if !o.Force && !config.Stale(lease) {
+		return errors.New("the lock is still held, pass --force if you are sure that run is gone")
+	}
+
+	err = store.BreakLease(ctx, o.ClusterName)
+	if err != nil {
+		return err
+	}
+	color.HiYellow("unlocked cluster \"%s\"", o.ClusterName)
+	return nil
+}
+
+// lockCluster takes the lock of the named cluster for operation. The
+// returned context is cancelled when the lock is lost; unlock has to be
+// called once the operation is over.
+func lockCluster(ctx context.Context, name, operation string) (context.Context, func(), error) {
+	store, err := config.Store()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lockCtx, l, err := store.LockCluster(ctx, name, operation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock := func() {
+		err := l.Unlock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to unlock cluster \"%s\": %v\n", name, err)
+		}
+	}
+	return lockCtx, unlock, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newUnlockCmd())
+}
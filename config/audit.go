@@ -0,0 +1,102 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PermIssue is a file below the kmanager config dir which is not kept as
+// private as it should be.
+type PermIssue struct {
+	Path    string
+	Mode    os.FileMode
+	Problem string
+
+	fix func() error
+}
+
+// Fix resolves the issue. Encrypting a key needs a passphrase.
+func (i PermIssue) Fix() error {
+	return i.fix()
+}
+
+// AuditPerms checks dir and everything below it: directories must only be
+// accessible by the user, files only readable by the user, and service
+// account keys must be encrypted. Modes are not checked on Windows, where
+// they do not reflect who can read a file.
+func AuditPerms(dir string) ([]PermIssue, error) {
+	checkModes := runtime.GOOS != "windows"
+
+	var issues []PermIssue
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		mode := fi.Mode().Perm()
+		want := os.FileMode(0600)
+		kind := "file"
+		if fi.IsDir() {
+			want, kind = 0700, "directory"
+		}
+
+		if checkModes && mode&0077 != 0 {
+			path := p
+			issues = append(issues, PermIssue{
+				Path:    p,
+				Mode:    mode,
+				Problem: fmt.Sprintf("%s is accessible by other users, expected mode %#o", kind, want),
+				fix:     func() error { return os.Chmod(path, want) },
+			})
+		}
+
+		if !fi.IsDir() && isPlainKey(p) {
+			path := p
+			issues = append(issues, PermIssue{
+				Path:    p,
+				Mode:    mode,
+				Problem: "service account key is not encrypted",
+				fix: func() error {
+					passphrase, err := Passphrase()
+					if err != nil {
+						return err
+					}
+					_, err = SealFile(path, passphrase)
+					return err
+				},
+			})
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return issues, err
+}
+
+// isPlainKey reports whether the file at p is an unencrypted service
+// account key.
+func isPlainKey(p string) bool {
+	if !strings.HasSuffix(p, ".json") {
+		return false
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return false
+	}
+
+	var key struct {
+		Type       string `json:"type"`
+		PrivateKey string `json:"private_key"`
+	}
+	return json.Unmarshal(b, &key) == nil && key.Type == "service_account" && key.PrivateKey != ""
+}
@@ -7,15 +7,26 @@ import (
 	"path/filepath"
 )
 
+// HomeEnv relocates the kmanager config dir when --config-dir is not given.
+const HomeEnv = "KMANAGER_HOME"
+
+// Home is the kmanager config dir, holding a dir per cluster. When empty
+// the kmanager dir in the user config dir is used.
+var Home = os.Getenv(HomeEnv)
+
 func CreateConfigDir(clusterName string) (string, error) {
-	confPath, err := os.UserConfigDir()
+	kConfPath, err := ClusterDir(clusterName)
 	if err != nil {
 		return "", err
 	}
 
-	kConfPath := filepath.Join(confPath, "kmanager", clusterName)
+	// keys of the cluster are kept in here, so only the user may enter it
+	err = os.MkdirAll(kConfPath, 0700)
+	if err != nil {
+		return "", err
+	}
 
-	err = os.MkdirAll(kConfPath, 0777)
+	err = os.Chmod(kConfPath, 0700)
 	if err != nil {
 		return "", err
 	}
@@ -23,13 +34,22 @@ func CreateConfigDir(clusterName string) (string, error) {
 	return kConfPath, nil
 }
 
-func ClusterPath(clusterName string) (string, error) {
-	confPath, err := os.UserConfigDir()
+// ClusterDir returns the config directory of the cluster without creating
+// or checking it.
+func ClusterDir(clusterName string) (string, error) {
+	confPath, err := KmanagerConfigPath()
 	if err != nil {
 		return "", err
 	}
 
-	kConfPath := filepath.Join(confPath, "kmanager", clusterName)
+	return filepath.Join(confPath, clusterName), nil
+}
+
+func ClusterPath(clusterName string) (string, error) {
+	kConfPath, err := ClusterDir(clusterName)
+	if err != nil {
+		return "", err
+	}
 
 	if _, err := os.Stat(kConfPath); errors.Is(err, os.ErrNotExist) {
 		return "", fmt.Errorf("No cluster found with name \"%s\"", clusterName)
@@ -38,7 +58,12 @@ func ClusterPath(clusterName string) (string, error) {
 	return kConfPath, nil
 }
 
+// KmanagerConfigPath returns the kmanager config dir, Home if set.
 func KmanagerConfigPath() (string, error) {
+	if Home != "" {
+		return filepath.Abs(Home)
+	}
+
 	confPath, err := os.UserConfigDir()
 	if err != nil {
 		return "", err
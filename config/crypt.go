@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// PassphraseEnv holds the passphrase key material is encrypted with.
+	PassphraseEnv = "KMANAGER_PASSPHRASE"
+	// KeyFileEnv names a file holding the passphrase, used when
+	// PassphraseEnv is not set.
+	KeyFileEnv = "KMANAGER_KEY_FILE"
+	// EncryptedExt is appended to the name of an encrypted file.
+	EncryptedExt = ".enc"
+)
+
+// ErrNoPassphrase is returned by Passphrase when none is set.
+var ErrNoPassphrase = fmt.Errorf("no passphrase set, export %s or %s", PassphraseEnv, KeyFileEnv)
+
+// encMagic starts every encrypted file. It is followed by the scrypt salt,
+// the AES-GCM nonce and the sealed data.
+var encMagic = []byte("kmanager-enc-v1\n")
+
+const (
+	saltSize = 16
+	// scrypt parameters recommended for interactive use
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Passphrase returns the passphrase from PassphraseEnv or the file named by
+// KeyFileEnv.
+func Passphrase() ([]byte, error) {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+
+	if f := os.Getenv(KeyFileEnv); f != "" {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read key file: %v", err)
+		}
+		b = bytes.TrimRight(b, "\r\n")
+		if len(b) == 0 {
+			return nil, fmt.Errorf("key file %s is empty", f)
+		}
+		return b, nil
+	}
+
+	return nil, ErrNoPassphrase
+}
+
+func newGCM(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt seals data with a key derived from passphrase.
+func Encrypt(data, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append(append(append([]byte{}, encMagic...), salt...), nonce...)
+	return gcm.Seal(out, nonce, data, encMagic), nil
+}
+
+// IsEncrypted reports whether data was sealed by Encrypt.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encMagic)
+}
+
+// Decrypt opens data sealed by Encrypt.
+func Decrypt(data, passphrase []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("data is not encrypted by kmanager")
+	}
+	data = data[len(encMagic):]
+	if len(data) < saltSize {
+		return nil, errors.New("encrypted data is truncated")
+	}
+
+	gcm, err := newGCM(passphrase, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], encMagic)
+	if err != nil {
+		return nil, errors.New("unable to decrypt, wrong passphrase or corrupted data")
+	}
+	return plain, nil
+}
+
+// SealFile encrypts the file at path into path+EncryptedExt and removes the
+// plaintext. It returns the path of the encrypted file.
+func SealFile(path string, passphrase []byte) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := Encrypt(data, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	encPath := path + EncryptedExt
+	err = ioutil.WriteFile(encPath, sealed, 0600)
+	if err != nil {
+		return "", err
+	}
+	return encPath, os.Remove(path)
+}
+
+// ReadSealed returns the content of the file at path, decrypting
+// path+EncryptedExt instead when the plaintext is not there.
+func ReadSealed(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return data, err
+	}
+
+	data, err = ioutil.ReadFile(path + EncryptedExt)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := Passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("%s is encrypted: %w", path+EncryptedExt, err)
+	}
+	return Decrypt(data, passphrase)
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setenv sets key to value for the duration of the test, an empty value
+// unsets it.
+func setenv(t *testing.T, key, value string) {
+	old, had := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kmanager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestDecrypt(t *testing.T) {
+	sealed, err := Encrypt([]byte("key material"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		want       string
+		wantErr    string
+	}{
+		{name: "round trip", data: sealed, passphrase: "secret", want: "key material"},
+		{name: "wrong passphrase", data: sealed, passphrase: "guess", wantErr: "wrong passphrase"},
+		{name: "tampered", data: tampered, passphrase: "secret", wantErr: "corrupted data"},
+		{name: "plaintext", data: []byte(`{"type":"service_account"}`), passphrase: "secret", wantErr: "not encrypted"},
+		{name: "truncated salt", data: sealed[:len(encMagic)+saltSize-1], passphrase: "secret", wantErr: "truncated"},
+		{name: "truncated nonce", data: sealed[:len(encMagic)+saltSize+1], passphrase: "secret", wantErr: "truncated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.data, []byte(tt.passphrase))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decrypt = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Decrypt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncryptSaltsEveryCall(t *testing.T) {
+	a, err := Encrypt([]byte("key material"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Encrypt([]byte("key material"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("encrypting twice gave the same output")
+	}
+	if bytes.Contains(a, []byte("key material")) {
+		t.Error("plaintext found in the encrypted output")
+	}
+}
+
+func TestSealFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		keyFile    string
+		read       string
+		wantErr    error
+	}{
+		{name: "passphrase", passphrase: "secret", read: "secret"},
+		{name: "key file", keyFile: "secret\n", read: "secret"},
+		{name: "passphrase before key file", passphrase: "secret", keyFile: "other", read: "secret"},
+		{name: "no passphrase", read: "secret", wantErr: ErrNoPassphrase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			path := filepath.Join(dir, "demo-storage.json")
+			err := ioutil.WriteFile(path, []byte(`{"type":"service_account"}`), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encPath, err := SealFile(path, []byte(tt.read))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encPath != path+EncryptedExt {
+				t.Errorf("SealFile = %s, want %s", encPath, path+EncryptedExt)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("plaintext %s left behind", path)
+			}
+			fi, err := os.Stat(encPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0600 {
+				t.Errorf("%s has mode %v, want 0600", encPath, fi.Mode().Perm())
+			}
+
+			setenv(t, PassphraseEnv, tt.passphrase)
+			setenv(t, KeyFileEnv, "")
+			if tt.keyFile != "" {
+				keyFile := filepath.Join(dir, "key")
+				if err := ioutil.WriteFile(keyFile, []byte(tt.keyFile), 0600); err != nil {
+					t.Fatal(err)
+				}
+				setenv(t, KeyFileEnv, keyFile)
+			}
+
+			data, err := ReadSealed(path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ReadSealed = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != `{"type":"service_account"}` {
+				t.Errorf("ReadSealed = %q", data)
+			}
+		})
+	}
+}
+
+func TestReadSealedPrefersPlaintext(t *testing.T) {
+	setenv(t, PassphraseEnv, "")
+	setenv(t, KeyFileEnv, "")
+
+	dir := tempDir(t)
+	path := filepath.Join(dir, "demo-storage.json")
+	if err := ioutil.WriteFile(path, []byte("plain"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ReadSealed(path)
+	if err != nil || string(data) != "plain" {
+		t.Errorf("ReadSealed = %q, %v, want the plaintext", data, err)
+	}
+
+	_, err = ReadSealed(filepath.Join(dir, "missing.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadSealed of a missing file = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LockTTL is how long a lock outlives the process holding it. The lock is
+// renewed while the process runs, so a crashed run frees it after LockTTL.
+var LockTTL = 2 * time.Minute
+
+// ErrLocked is returned when another run holds the lock of a cluster.
+var ErrLocked = errors.New("cluster is locked")
+
+// Lock is an advisory lock of a cluster, held by one mutating kmanager run
+// at a time. It is a lease in the state store, so it covers everyone
+// sharing that store.
+type Lock struct {
+	Lease *Lease
+
+	store  *StateStore
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// LockCluster locks cluster for operation. The returned context is
+// cancelled when the lock is lost, e.g. broken with 'kmanager unlock
+// --force', so the operation stops mutating a cluster it no longer owns.
+func (s *StateStore) LockCluster(ctx context.Context, cluster, operation string) (context.Context, *Lock, error) {
+	req := Lease{
+		Cluster:   cluster,
+		Owner:     currentUser(),
+		PID:       os.Getpid(),
+		Operation: operation,
+	}
+	req.Host, _ = os.Hostname()
+	req.Holder = fmt.Sprintf("%s@%s:%d", req.Owner, req.Host, req.PID)
+
+	lease, err := s.AcquireLease(ctx, req, LockTTL)
+	if errors.Is(err, ErrLeaseHeld) && lease != nil && Stale(lease) {
+		// the holder is gone without unlocking
+		err = s.BreakLease(ctx, cluster)
+		if err != nil {
+			return nil, nil, err
+		}
+		lease, err = s.AcquireLease(ctx, req, LockTTL)
+	}
+	if errors.Is(err, ErrLeaseHeld) && lease != nil {
+		return nil, nil, fmt.Errorf("%w: %s\nif that run is gone, run 'kmanager unlock %s --force'", ErrLocked, Describe(lease), cluster)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	l := &Lock{Lease: lease, store: s, cancel: cancel, done: make(chan struct{})}
+	go l.renew(lockCtx)
+	return lockCtx, l, nil
+}
+
+func (l *Lock) renew(ctx context.Context) {
+	defer close(l.done)
+
+	t := time.NewTicker(LockTTL / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			err := l.store.RenewLease(ctx, l.Lease, LockTTL)
+			if errors.Is(err, ErrLeaseHeld) {
+				fmt.Fprintf(os.Stderr, "lost the lock of cluster \"%s\", stopping\n", l.Lease.Cluster)
+				l.cancel()
+				return
+			}
+			// other failures are retried on the next tick, the lease
+			// lasts a few of them
+		}
+	}
+}
+
+// Unlock releases the lock. It is safe to call more than once.
+func (l *Lock) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		l.cancel()
+		<-l.done
+		err = l.store.ReleaseLease(context.Background(), l.Lease)
+	})
+	return err
+}
+
+// Stale reports whether the holder of l is known to be gone: the lease ran
+// out or its process no longer runs on this host.
+func Stale(l *Lease) bool {
+	if l.Expired() {
+		return true
+	}
+	host, _ := os.Hostname()
+	return l.Host == host && l.PID > 0 && !processAlive(l.PID)
+}
+
+// Describe tells who holds l and what for.
+func Describe(l *Lease) string {
+	return fmt.Sprintf("cluster \"%s\" is locked by %s on %s (pid %d) running %s since %s",
+		l.Cluster, l.Owner, l.Host, l.PID, l.Operation, l.Started.Local().Format(time.RFC1123))
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "uid-" + strconv.Itoa(os.Getuid())
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package config
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive reports whether a process of given pid runs. Signal 0 only
+// checks for its existence, EPERM means it runs as another user.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package config
+
+import "syscall"
+
+const processQueryLimitedInformation = 0x1000
+
+// processAlive reports whether a process of given pid runs.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	err = syscall.GetExitCodeProcess(h, &code)
+	// STILL_ACTIVE
+	return err == nil && code == 259
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ConfigFile is the name of the cluster state inside a cluster dir.
+const ConfigFile = "config.json"
+
+// StateStoreEnv selects the state store when --state-store is not given.
+const StateStoreEnv = "KMANAGER_STATE_STORE"
+
+var (
+	// ErrStateNotFound is returned when a key does not exist in the store.
+	ErrStateNotFound = errors.New("state not found")
+	// ErrConflict is returned when a conditional write or remove finds a
+	// different version than expected, i.e. someone else changed the state
+	// in the meantime.
+	ErrConflict = errors.New("state was changed concurrently")
+	// ErrLeaseHeld is returned when someone else holds an unexpired lease.
+	ErrLeaseHeld = errors.New("lease is held by someone else")
+)
+
+// StateStoreURI is the location of the state store: empty or "local" for
+// the kmanager config dir, "file:///some/dir" for another directory or an
+// http(s) URL for a remote store.
+var StateStoreURI = os.Getenv(StateStoreEnv)
+
+// Backend stores opaque objects under slash separated keys. Every object has
+// a version which changes whenever it is written, writes and removes can be
+// made conditional on it.
+type Backend interface {
+	// Read returns the object stored under key and its version.
+	Read(ctx context.Context, key string) ([]byte, string, error)
+	// Write stores data under key if its current version is ifVersion. An
+	// empty ifVersion only creates, AnyVersion writes unconditionally.
+	Write(ctx context.Context, key string, data []byte, ifVersion string) (string, error)
+	// Remove deletes key if its current version is ifVersion, AnyVersion
+	// removes unconditionally.
+	Remove(ctx context.Context, key string, ifVersion string) error
+	// Keys returns every key starting with prefix, sorted.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// AnyVersion makes Write and Remove unconditional.
+const AnyVersion = "*"
+
+// StateStore keeps the config of every cluster on top of a Backend. It is
+// shared by everyone pointing at the same backend, so writes are conditional
+// on the version read and mutations can be guarded by leases.
+type StateStore struct {
+	Backend Backend
+	// Local is set when the backend is the kmanager config dir itself, the
+	// working files of a cluster then live right next to its state.
+	Local bool
+}
+
+// OpenStateStore returns the store at uri, see StateStoreURI.
+func OpenStateStore(uri string) (*StateStore, error) {
+	switch {
+	case uri == "" || uri == "local":
+		dir, err := KmanagerConfigPath()
+		if err != nil {
+			return nil, err
+		}
+		return &StateStore{Backend: NewLocalBackend(dir), Local: true}, nil
+	case strings.HasPrefix(uri, "file://"):
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		return &StateStore{Backend: NewLocalBackend(u.Path)}, nil
+	case strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://"):
+		return &StateStore{Backend: NewHTTPBackend(uri, os.Getenv(StateTokenEnv))}, nil
+	}
+	return nil, fmt.Errorf("unsupported state store %q, expected local, file:// or http(s)://", uri)
+}
+
+// Store opens the state store selected by StateStoreURI.
+func Store() (*StateStore, error) {
+	return OpenStateStore(StateStoreURI)
+}
+
+func configKey(cluster string) string {
+	return path.Join(cluster, ConfigFile)
+}
+
+func leaseKey(cluster string) string {
+	return path.Join("locks", cluster+".lock")
+}
+
+// Get returns the config of cluster and its version.
+func (s *StateStore) Get(ctx context.Context, cluster string) ([]byte, string, error) {
+	return s.Backend.Read(ctx, configKey(cluster))
+}
+
+// Put stores the config of cluster if its version is still version, an
+// empty version only creates the cluster.
+func (s *StateStore) Put(ctx context.Context, cluster string, data []byte, version string) (string, error) {
+	return s.Backend.Write(ctx, configKey(cluster), data, version)
+}
+
+// Delete removes the config of cluster.
+func (s *StateStore) Delete(ctx context.Context, cluster string) error {
+	err := s.Backend.Remove(ctx, configKey(cluster), AnyVersion)
+	if errors.Is(err, ErrStateNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Backup stores data next to the config of cluster under a name ending in
+// tag, so it is not picked up as a config, and returns its key.
+func (s *StateStore) Backup(ctx context.Context, cluster, tag string, data []byte) (string, error) {
+	key := path.Join(cluster, ConfigFile+"."+tag+".bak")
+	_, err := s.Backend.Write(ctx, key, data, AnyVersion)
+	return key, err
+}
+
+// List returns the names of every cluster in the store.
+func (s *StateStore) List(ctx context.Context) ([]string, error) {
+	keys, err := s.Backend.Keys(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []string
+	for _, k := range keys {
+		dir, file := path.Split(k)
+		dir = strings.TrimSuffix(dir, "/")
+		if file == ConfigFile && dir != "" && !strings.Contains(dir, "/") {
+			clusters = append(clusters, dir)
+		}
+	}
+	return clusters, nil
+}
+
+// Lease is the right of Holder to mutate Cluster until Expires. The other
+// fields tell who holds it.
+type Lease struct {
+	Cluster   string    `json:"cluster"`
+	Holder    string    `json:"holder"`
+	Owner     string    `json:"owner,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+	Operation string    `json:"operation,omitempty"`
+	Started   time.Time `json:"started"`
+	Expires   time.Time `json:"expires"`
+
+	version string
+}
+
+// Expired reports whether the lease ran out.
+func (l *Lease) Expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+// AcquireLease takes the lease on req.Cluster for req.Holder for ttl. While
+// someone else holds an unexpired lease it fails with ErrLeaseHeld,
+// returning their lease.
+func (s *StateStore) AcquireLease(ctx context.Context, req Lease, ttl time.Duration) (*Lease, error) {
+	current, err := s.GetLease(ctx, req.Cluster)
+	ifVersion := ""
+	switch {
+	case errors.Is(err, ErrStateNotFound):
+	case err != nil:
+		return nil, err
+	case !current.Expired() && current.Holder != req.Holder:
+		return current, fmt.Errorf("%w: cluster \"%s\" is held by %s until %s", ErrLeaseHeld, req.Cluster, current.Holder, current.Expires.Format(time.RFC3339))
+	default:
+		ifVersion = current.version
+	}
+
+	l := req
+	if l.Started.IsZero() {
+		l.Started = time.Now()
+	}
+	l.Expires = time.Now().Add(ttl)
+	err = s.writeLease(ctx, &l, ifVersion)
+	if errors.Is(err, ErrConflict) {
+		current, _ = s.GetLease(ctx, req.Cluster)
+		return current, fmt.Errorf("%w: cluster \"%s\" was leased concurrently", ErrLeaseHeld, req.Cluster)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// RenewLease extends l by ttl, failing if it was taken over meanwhile.
+func (s *StateStore) RenewLease(ctx context.Context, l *Lease, ttl time.Duration) error {
+	renewed := *l
+	renewed.Expires = time.Now().Add(ttl)
+	err := s.writeLease(ctx, &renewed, l.version)
+	if errors.Is(err, ErrConflict) || errors.Is(err, ErrStateNotFound) {
+		return fmt.Errorf("%w: lease on cluster \"%s\" was lost", ErrLeaseHeld, l.Cluster)
+	}
+	if err != nil {
+		return err
+	}
+	*l = renewed
+	return nil
+}
+
+// ReleaseLease gives l up, unless someone else took it over already.
+func (s *StateStore) ReleaseLease(ctx context.Context, l *Lease) error {
+	err := s.Backend.Remove(ctx, leaseKey(l.Cluster), l.version)
+	if errors.Is(err, ErrStateNotFound) || errors.Is(err, ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+// BreakLease removes the lease on cluster whoever holds it.
+func (s *StateStore) BreakLease(ctx context.Context, cluster string) error {
+	err := s.Backend.Remove(ctx, leaseKey(cluster), AnyVersion)
+	if errors.Is(err, ErrStateNotFound) {
+		return nil
+	}
+	return err
+}
+
+// GetLease returns the current lease on cluster.
+func (s *StateStore) GetLease(ctx context.Context, cluster string) (*Lease, error) {
+	b, version, err := s.Backend.Read(ctx, leaseKey(cluster))
+	if err != nil {
+		return nil, err
+	}
+
+	var l Lease
+	err = json.Unmarshal(b, &l)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lease of cluster \"%s\": %v", cluster, err)
+	}
+	l.version = version
+	return &l, nil
+}
+
+func (s *StateStore) writeLease(ctx context.Context, l *Lease, ifVersion string) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	version, err := s.Backend.Write(ctx, leaseKey(l.Cluster), b, ifVersion)
+	if err != nil {
+		return err
+	}
+	l.version = version
+	return nil
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	kh "github.com/urvil38/kmanager/http"
+)
+
+// StateTokenEnv holds the bearer token sent to a remote state store.
+const StateTokenEnv = "KMANAGER_STATE_TOKEN"
+
+// HTTPBackend talks to an object store over plain HTTP, the way GCS and S3
+// style stores do: objects are read, written and removed with GET, PUT and
+// DELETE on BaseURL/key, the ETag header carries the version and writes are
+// made conditional through If-Match and If-None-Match. Keys are listed with
+// GET BaseURL/?prefix=, answering a JSON array. NewBackendHandler serves
+// this protocol.
+type HTTPBackend struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+}
+
+func NewHTTPBackend(baseURL, token string) *HTTPBackend {
+	timeout := 30 * time.Second
+	return &HTTPBackend{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		Client:  kh.NewHTTPClient(&timeout),
+	}
+}
+
+func (b *HTTPBackend) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.BaseURL+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if b.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.Token)
+	}
+
+	res, err := b.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, data, nil
+}
+
+// statusError maps the status of a failed request to the store errors.
+func statusError(method, key string, res *http.Response, body []byte) error {
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return fmt.Errorf("%w: %s", ErrConflict, key)
+	}
+	return fmt.Errorf("state store: %s %s: %s: %s", method, key, res.Status, strings.TrimSpace(string(body)))
+}
+
+func conditions(ifVersion string) http.Header {
+	h := http.Header{}
+	switch ifVersion {
+	case AnyVersion:
+	case "":
+		h.Set("If-None-Match", "*")
+	default:
+		h.Set("If-Match", ifVersion)
+	}
+	return h
+}
+
+func (b *HTTPBackend) Read(ctx context.Context, key string) ([]byte, string, error) {
+	res, data, err := b.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", statusError(http.MethodGet, key, res, data)
+	}
+	return data, res.Header.Get("ETag"), nil
+}
+
+func (b *HTTPBackend) Write(ctx context.Context, key string, data []byte, ifVersion string) (string, error) {
+	res, body, err := b.do(ctx, http.MethodPut, key, data, conditions(ifVersion))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return "", statusError(http.MethodPut, key, res, body)
+	}
+	return res.Header.Get("ETag"), nil
+}
+
+func (b *HTTPBackend) Remove(ctx context.Context, key string, ifVersion string) error {
+	if ifVersion == "" {
+		ifVersion = AnyVersion
+	}
+	res, body, err := b.do(ctx, http.MethodDelete, key, nil, conditions(ifVersion))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return statusError(http.MethodDelete, key, res, body)
+	}
+	return nil
+}
+
+func (b *HTTPBackend) Keys(ctx context.Context, prefix string) ([]string, error) {
+	res, body, err := b.do(ctx, http.MethodGet, "?prefix="+url.QueryEscape(prefix), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(http.MethodGet, "?prefix="+prefix, res, body)
+	}
+
+	var keys []string
+	err = json.Unmarshal(body, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("state store: invalid key list: %v", err)
+	}
+	return keys, nil
+}
+
+// NewBackendHandler serves b over the protocol spoken by HTTPBackend. Put
+// in front of a LocalBackend it is a stand-in for a remote store.
+func NewBackendHandler(b Backend) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		ctx := r.Context()
+
+		fail := func(err error) {
+			switch {
+			case errors.Is(err, ErrStateNotFound):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, ErrConflict):
+				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}
+
+		// the version the request is conditional on
+		ifVersion := AnyVersion
+		if r.Header.Get("If-None-Match") == "*" {
+			ifVersion = ""
+		} else if v := r.Header.Get("If-Match"); v != "" {
+			ifVersion = v
+		}
+
+		switch {
+		case r.Method == http.MethodGet && key == "":
+			keys, err := b.Keys(ctx, r.URL.Query().Get("prefix"))
+			if err != nil {
+				fail(err)
+				return
+			}
+			if keys == nil {
+				keys = []string{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(keys)
+		case r.Method == http.MethodGet:
+			data, version, err := b.Read(ctx, key)
+			if err != nil {
+				fail(err)
+				return
+			}
+			w.Header().Set("ETag", version)
+			_, _ = w.Write(data)
+		case r.Method == http.MethodPut:
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				fail(err)
+				return
+			}
+			version, err := b.Write(ctx, key, data, ifVersion)
+			if err != nil {
+				fail(err)
+				return
+			}
+			w.Header().Set("ETag", version)
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodDelete:
+			err := b.Remove(ctx, key, ifVersion)
+			if err != nil {
+				fail(err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
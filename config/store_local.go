@@ -0,0 +1,248 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LocalBackend stores objects as files below Dir. Versions are content
+// hashes. Conditional writes and removes are atomic across processes, they
+// hold a lock file next to the object while checking and replacing it.
+type LocalBackend struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// lockStale is how old a lock file has to be to count as left behind by a
+// crashed process. Holding it only spans a check and a rename.
+const lockStale = 10 * time.Second
+
+// testHookReplace, when set, is called between checking and replacing an
+// object.
+var testHookReplace func()
+
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{Dir: dir}
+}
+
+func (b *LocalBackend) path(key string) (string, error) {
+	p := filepath.Join(b.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(b.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return p, nil
+}
+
+// lock takes the lock file of the object at p, waiting while another
+// process holds it. The returned func releases it.
+func lock(ctx context.Context, p string) (func(), error) {
+	lockPath := filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".lock")
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if fi, err := os.Stat(lockPath); err == nil && time.Since(fi.ModTime()) > lockStale {
+			os.Remove(lockPath)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (b *LocalBackend) Read(ctx context.Context, key string) ([]byte, string, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	} else if err != nil {
+		return nil, "", err
+	}
+	return data, contentVersion(data), nil
+}
+
+// check compares the version of the object at p with ifVersion.
+func (b *LocalBackend) check(p, key, ifVersion string) error {
+	if ifVersion == AnyVersion {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(p)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && ifVersion == "":
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	case err != nil:
+		return err
+	case ifVersion == "":
+		return fmt.Errorf("%w: %s already exists", ErrConflict, key)
+	case contentVersion(data) != ifVersion:
+		return fmt.Errorf("%w: %s", ErrConflict, key)
+	}
+	return nil
+}
+
+func (b *LocalBackend) Write(ctx context.Context, key string, data []byte, ifVersion string) (string, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	if ifVersion != AnyVersion {
+		unlock, err := lock(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+	}
+
+	err = b.check(p, key, ifVersion)
+	if err != nil {
+		return "", err
+	}
+
+	// write next to the object and rename, so readers never see half of it
+	tmp, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Chmod(tmp.Name(), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	if testHookReplace != nil {
+		testHookReplace()
+	}
+
+	if ifVersion == "" {
+		// linking fails if p exists, which makes creating atomic even
+		// across processes
+		err = os.Link(tmp.Name(), p)
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("%w: %s already exists", ErrConflict, key)
+		} else if err != nil {
+			return "", err
+		}
+		return contentVersion(data), nil
+	}
+
+	err = os.Rename(tmp.Name(), p)
+	if err != nil {
+		return "", err
+	}
+	return contentVersion(data), nil
+}
+
+func (b *LocalBackend) Remove(ctx context.Context, key string, ifVersion string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ifVersion == "" {
+		ifVersion = AnyVersion
+	}
+	if ifVersion != AnyVersion {
+		unlock, err := lock(ctx, p)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrStateNotFound, key)
+		} else if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	err = b.check(p, key, ifVersion)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	}
+	return err
+}
+
+func (b *LocalBackend) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(b.Dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.Dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
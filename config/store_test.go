@@ -0,0 +1,367 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backends returns a constructor for every backend, each returning a new
+// client of one shared store.
+func backends(t *testing.T) map[string]func() Backend {
+	local := tempDir(t)
+	srv := httptest.NewServer(NewBackendHandler(NewLocalBackend(tempDir(t))))
+	t.Cleanup(srv.Close)
+
+	return map[string]func() Backend{
+		"local": func() Backend { return NewLocalBackend(local) },
+		"http":  func() Backend { return NewHTTPBackend(srv.URL, "") },
+	}
+}
+
+func TestBackend(t *testing.T) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			b := open()
+
+			_, _, err := b.Read(ctx, "demo/config.json")
+			if !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("Read of a missing key = %v, want ErrStateNotFound", err)
+			}
+			_, err = b.Write(ctx, "demo/config.json", []byte("v0"), "some-version")
+			if !errors.Is(err, ErrStateNotFound) {
+				t.Errorf("conditional Write of a missing key = %v, want ErrStateNotFound", err)
+			}
+
+			v1, err := b.Write(ctx, "demo/config.json", []byte("v1"), "")
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			_, err = b.Write(ctx, "demo/config.json", []byte("again"), "")
+			if !errors.Is(err, ErrConflict) {
+				t.Errorf("second create = %v, want ErrConflict", err)
+			}
+
+			data, version, err := b.Read(ctx, "demo/config.json")
+			if err != nil || string(data) != "v1" || version != v1 {
+				t.Fatalf("Read = %q, %q, %v, want v1 at version %q", data, version, err, v1)
+			}
+
+			v2, err := b.Write(ctx, "demo/config.json", []byte("v2"), v1)
+			if err != nil {
+				t.Fatalf("Write at the current version: %v", err)
+			}
+			if v2 == v1 {
+				t.Errorf("version %q unchanged by a write", v2)
+			}
+			_, err = b.Write(ctx, "demo/config.json", []byte("lost update"), v1)
+			if !errors.Is(err, ErrConflict) {
+				t.Errorf("Write at a stale version = %v, want ErrConflict", err)
+			}
+			v3, err := b.Write(ctx, "demo/config.json", []byte("v3"), AnyVersion)
+			if err != nil {
+				t.Fatalf("unconditional Write: %v", err)
+			}
+
+			err = b.Remove(ctx, "demo/config.json", v2)
+			if !errors.Is(err, ErrConflict) {
+				t.Errorf("Remove at a stale version = %v, want ErrConflict", err)
+			}
+			err = b.Remove(ctx, "demo/config.json", v3)
+			if err != nil {
+				t.Fatalf("Remove at the current version: %v", err)
+			}
+			err = b.Remove(ctx, "demo/config.json", AnyVersion)
+			if !errors.Is(err, ErrStateNotFound) {
+				t.Errorf("Remove of a missing key = %v, want ErrStateNotFound", err)
+			}
+		})
+	}
+}
+
+func TestBackendKeys(t *testing.T) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			b := open()
+
+			keys, err := b.Keys(ctx, "")
+			if err != nil || len(keys) != 0 {
+				t.Fatalf("Keys of an empty store = %v, %v", keys, err)
+			}
+
+			for _, key := range []string{"other/config.json", "locks/demo.lock", "demo/config.json"} {
+				if _, err := b.Write(ctx, key, []byte(key), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tests := []struct {
+				prefix string
+				want   []string
+			}{
+				{"", []string{"demo/config.json", "locks/demo.lock", "other/config.json"}},
+				{"locks/", []string{"locks/demo.lock"}},
+				{"missing/", nil},
+			}
+			for _, tt := range tests {
+				keys, err := b.Keys(ctx, tt.prefix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(keys) != 0 || len(tt.want) != 0 {
+					if !reflect.DeepEqual(keys, tt.want) {
+						t.Errorf("Keys(%q) = %v, want %v", tt.prefix, keys, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestBackendConcurrentWrites has every writer use its own client, so for
+// the local backend only the files arbitrate.
+func TestBackendConcurrentWrites(t *testing.T) {
+	const writers = 8
+
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			tests := []struct {
+				name      string
+				ifVersion func() string
+			}{
+				{"create", func() string { return "" }},
+				{"update", func() string {
+					v, err := open().Write(ctx, "update/config.json", []byte("v0"), "")
+					if err != nil {
+						t.Fatal(err)
+					}
+					return v
+				}},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					key := tt.name + "/config.json"
+					ifVersion := tt.ifVersion()
+
+					var wg sync.WaitGroup
+					errs := make([]error, writers)
+					for i := 0; i < writers; i++ {
+						wg.Add(1)
+						go func(i int) {
+							defer wg.Done()
+							_, errs[i] = open().Write(ctx, key, []byte(fmt.Sprint("writer ", i)), ifVersion)
+						}(i)
+					}
+					wg.Wait()
+
+					won := 0
+					for _, err := range errs {
+						switch {
+						case err == nil:
+							won++
+						case !errors.Is(err, ErrConflict):
+							t.Errorf("Write = %v, want ErrConflict for the losers", err)
+						}
+					}
+					if won != 1 {
+						t.Errorf("%d of %d concurrent writes succeeded, want 1", won, writers)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestLease(t *testing.T) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := &StateStore{Backend: open()}
+
+			a, err := s.AcquireLease(ctx, Lease{Cluster: "demo", Holder: "a"}, 50*time.Millisecond)
+			if err != nil {
+				t.Fatalf("AcquireLease: %v", err)
+			}
+			held, err := s.AcquireLease(ctx, Lease{Cluster: "demo", Holder: "b"}, time.Minute)
+			if !errors.Is(err, ErrLeaseHeld) || held == nil || held.Holder != "a" {
+				t.Fatalf("AcquireLease of a held lease = %v, %v, want ErrLeaseHeld by a", held, err)
+			}
+			if err := s.RenewLease(ctx, a, 50*time.Millisecond); err != nil {
+				t.Fatalf("RenewLease: %v", err)
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			b, err := s.AcquireLease(ctx, Lease{Cluster: "demo", Holder: "b"}, time.Minute)
+			if err != nil {
+				t.Fatalf("AcquireLease of an expired lease: %v", err)
+			}
+
+			err = s.RenewLease(ctx, a, time.Minute)
+			if !errors.Is(err, ErrLeaseHeld) {
+				t.Errorf("RenewLease of a lease taken over = %v, want ErrLeaseHeld", err)
+			}
+			if err := s.ReleaseLease(ctx, a); err != nil {
+				t.Errorf("ReleaseLease of a lease taken over: %v", err)
+			}
+			if l, err := s.GetLease(ctx, "demo"); err != nil || l.Holder != "b" {
+				t.Errorf("releasing a lost lease removed the lease of b: %v, %v", l, err)
+			}
+
+			if err := s.ReleaseLease(ctx, b); err != nil {
+				t.Fatalf("ReleaseLease: %v", err)
+			}
+			if _, err := s.GetLease(ctx, "demo"); !errors.Is(err, ErrStateNotFound) {
+				t.Errorf("GetLease after release = %v, want ErrStateNotFound", err)
+			}
+		})
+	}
+}
+
+func TestLockClusterLost(t *testing.T) {
+	ttl := LockTTL
+	LockTTL = 30 * time.Millisecond
+	t.Cleanup(func() { LockTTL = ttl })
+
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := &StateStore{Backend: open()}
+			ctx, l, err := s.LockCluster(context.Background(), "demo", "create")
+			if err != nil {
+				t.Fatalf("LockCluster: %v", err)
+			}
+			defer l.Unlock()
+
+			other := &StateStore{Backend: open()}
+			if err := other.BreakLease(context.Background(), "demo"); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("lock context not cancelled after the lock was broken")
+			}
+		})
+	}
+}
+
+func TestBackendHandler(t *testing.T) {
+	srv := httptest.NewServer(NewBackendHandler(NewLocalBackend(tempDir(t))))
+	defer srv.Close()
+
+	do := func(method, key, body string, header ...string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/"+key, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	created := do(http.MethodPut, "demo/config.json", "v1", "If-None-Match", "*")
+	etag := created.Header.Get("ETag")
+	tests := []struct {
+		name   string
+		res    *http.Response
+		status int
+	}{
+		{"create", created, http.StatusOK},
+		{"create existing", do(http.MethodPut, "demo/config.json", "v1", "If-None-Match", "*"), http.StatusPreconditionFailed},
+		{"read", do(http.MethodGet, "demo/config.json", ""), http.StatusOK},
+		{"read missing", do(http.MethodGet, "other/config.json", ""), http.StatusNotFound},
+		{"stale If-Match", do(http.MethodPut, "demo/config.json", "v2", "If-Match", "stale"), http.StatusPreconditionFailed},
+		{"If-Match", do(http.MethodPut, "demo/config.json", "v2", "If-Match", etag), http.StatusOK},
+		{"delete at replaced ETag", do(http.MethodDelete, "demo/config.json", "", "If-Match", etag), http.StatusPreconditionFailed},
+		{"delete", do(http.MethodDelete, "demo/config.json", ""), http.StatusNoContent},
+		{"delete missing", do(http.MethodDelete, "demo/config.json", ""), http.StatusNotFound},
+		{"unknown method", do(http.MethodPost, "demo/config.json", "v3"), http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		if tt.res.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, tt.res.StatusCode, tt.status)
+		}
+	}
+	if etag == "" {
+		t.Error("create returned no ETag")
+	}
+}
+
+func TestLocalBackendKeyOutsideDir(t *testing.T) {
+	b := NewLocalBackend(tempDir(t))
+	for _, key := range []string{"../escape", "demo/../../escape", ""} {
+		if _, err := b.Write(context.Background(), key, []byte("x"), AnyVersion); err == nil || errors.Is(err, ErrConflict) {
+			t.Errorf("Write(%q) = %v, want an invalid key error", key, err)
+		}
+	}
+}
+
+// TestLeaseTakeoverAcrossBackends takes over an expired lease through two
+// independent backends on one dir, as two kmanager processes do. The first
+// is held between checking and replacing the lease while the second tries.
+func TestLeaseTakeoverAcrossBackends(t *testing.T) {
+	dir := tempDir(t)
+	ctx := context.Background()
+
+	s := &StateStore{Backend: NewLocalBackend(dir)}
+	_, err := s.AcquireLease(ctx, Lease{Cluster: "demo", Holder: "crashed"}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	testHookReplace = func() {
+		once.Do(func() {
+			close(held)
+			<-release
+		})
+	}
+	t.Cleanup(func() { testHookReplace = nil })
+
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	acquire := func(i int) {
+		defer wg.Done()
+		s := &StateStore{Backend: NewLocalBackend(dir)}
+		_, errs[i] = s.AcquireLease(ctx, Lease{Cluster: "demo", Holder: fmt.Sprint("racer ", i)}, time.Minute)
+	}
+
+	wg.Add(2)
+	go acquire(0)
+	<-held
+	go acquire(1)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if errs[0] != nil {
+		t.Errorf("first takeover: %v", errs[0])
+	}
+	if !errors.Is(errs[1], ErrLeaseHeld) {
+		t.Errorf("second takeover = %v, want ErrLeaseHeld", errs[1])
+	}
+	if l, err := s.GetLease(ctx, "demo"); err != nil || l.Holder != "racer 0" {
+		t.Errorf("lease held by %v, %v, want racer 0", l, err)
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/fatih/color v1.10.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/crypto v0.0.0-20190530122614-20be4c3c3ed5
 	golang.org/x/text v0.3.4 // indirect
 	gopkg.in/yaml.v2 v2.3.0
 )
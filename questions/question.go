@@ -2,12 +2,30 @@ package questions
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"strconv"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/fatih/color"
 )
 
+var (
+	clusterNameRegex = regexp.MustCompile(`^([^\W])(?:[a-zA-Z1-9-]+)$`)
+	dnsNameRegex     = regexp.MustCompile(`(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9][a-z0-9-]{0,61}[a-z0-9]`)
+)
+
+// IsValidClusterName reports whether name can be used as a cluster name.
+func IsValidClusterName(name string) bool {
+	return clusterNameRegex.MatchString(name)
+}
+
+// IsValidDomainName reports whether name can be used as the domain of a
+// cluster.
+func IsValidDomainName(name string) bool {
+	return dnsNameRegex.MatchString(name)
+}
+
 var clusterNameQ = survey.Question{
 	Name: "clusterName",
 	Prompt: &survey.Input{
@@ -15,8 +33,7 @@ var clusterNameQ = survey.Question{
 		Help:    "Please provide name of the kubepaas cluster",
 	},
 	Validate: func(val interface{}) error {
-		nameRegex := regexp.MustCompile(`^([^\W])(?:[a-zA-Z1-9-]+)$`)
-		if str, ok := val.(string); !ok || !nameRegex.Match([]byte(str)) {
+		if str, ok := val.(string); !ok || !IsValidClusterName(str) {
 			return errors.New("please enter valid name. Name can contains [ A-Z a-z 1-9 or `-` ]")
 		}
 		return nil
@@ -38,8 +55,7 @@ var dnsNameQ = survey.Question{
 		Help:    "Please provide domain name which will be used by kubepaas cluster",
 	},
 	Validate: func(val interface{}) error {
-		nameRegex := regexp.MustCompile(`(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9][a-z0-9-]{0,61}[a-z0-9]`)
-		if str, ok := val.(string); !ok || !nameRegex.Match([]byte(str)) {
+		if str, ok := val.(string); !ok || !IsValidDomainName(str) {
 			return errors.New("please enter valid domain name")
 		}
 		return nil
@@ -68,6 +84,18 @@ func RegionPrompt(options []string) []*survey.Question {
 	return append([]*survey.Question{}, &regionPrompt)
 }
 
+func LocationModePrompt(options []string) []*survey.Question {
+	locationModePrompt := survey.Question{
+		Name: "locationMode",
+		Prompt: &survey.Select{
+			Message: "Choose location type of the cluster:",
+			Options: options,
+			Help:    "zonal clusters run the control plane in a single zone, regional ones replicate it across the zones of the region",
+		},
+	}
+	return append([]*survey.Question{}, &locationModePrompt)
+}
+
 func ZonePrompt(options []string) []*survey.Question {
 	zonePrompt := survey.Question{
 		Name: "zone",
@@ -79,6 +107,81 @@ func ZonePrompt(options []string) []*survey.Question {
 	return append([]*survey.Question{}, &zonePrompt)
 }
 
+// NodeShapePrompt asks for the shape of the default node pool, offering the
+// given values as defaults.
+func NodeShapePrompt(machineType string, numNodes, diskSizeGB int, diskType, imageType string, preemptible bool) []*survey.Question {
+	return []*survey.Question{
+		{
+			Name: "machineType",
+			Prompt: &survey.Input{
+				Message: "Enter machine type of the nodes :",
+				Default: machineType,
+			},
+			Validate: survey.Required,
+		},
+		{
+			Name: "numNodes",
+			Prompt: &survey.Input{
+				Message: "Enter number of nodes :",
+				Default: strconv.Itoa(numNodes),
+			},
+			Validate: minInt(1),
+		},
+		{
+			Name: "diskSizeGB",
+			Prompt: &survey.Input{
+				Message: "Enter boot disk size of the nodes in GB :",
+				Default: strconv.Itoa(diskSizeGB),
+			},
+			Validate: minInt(10),
+		},
+		{
+			Name: "diskType",
+			Prompt: &survey.Select{
+				Message: "Choose boot disk type:",
+				Options: []string{"pd-standard", "pd-balanced", "pd-ssd"},
+				Default: diskType,
+			},
+		},
+		{
+			Name: "imageType",
+			Prompt: &survey.Input{
+				Message: "Enter node image type :",
+				Default: imageType,
+			},
+			Validate: survey.Required,
+		},
+		{
+			Name: "preemptible",
+			Prompt: &survey.Confirm{
+				Message: "Use preemptible nodes?",
+				Default: preemptible,
+			},
+		},
+	}
+}
+
+func minInt(min int) survey.Validator {
+	return func(val interface{}) error {
+		str, _ := val.(string)
+		n, err := strconv.Atoi(str)
+		if err != nil || n < min {
+			return fmt.Errorf("please enter a number, at least %d", min)
+		}
+		return nil
+	}
+}
+
+var nodeShapeConfirmationQ = survey.Question{
+	Name: "nodeShapeConfirmation",
+	Prompt: &survey.Confirm{
+		Message: "Customize the shape of the nodes?",
+		Default: false,
+	},
+}
+
+var NodeShapeConfirmation = append([]*survey.Question{}, &nodeShapeConfirmationQ)
+
 var ClusterName = append([]*survey.Question{}, &clusterNameQ)
 
 var DomainName = append([]*survey.Question{}, &dnsNameQ)